@@ -0,0 +1,364 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Players log in with a name and the secret they got when registering
+// The client keeps the secret, the server only keeps its hash
+
+// Elo ratings, new players start at InitialRating
+const InitialRating = 1500
+
+// Games before the rating settles
+const ProvisionalGames = 20
+
+const MaxNameLength = 20
+
+// Players sent by Leaderboard
+const LeaderboardSize = 50
+
+type Rating struct {
+    Rating float64
+    Games int
+}
+
+// Expected score of a against b
+func expected(a float64, b float64) float64 {
+    return 1 / (1 + math.Pow(10, (b - a) / 400))
+}
+
+// Score is 1 for a win, 0.5 for a draw and 0 for a loss
+func (r *Rating) update(opp float64, score float64) {
+    k := 20.0
+    if r.Games < ProvisionalGames {
+        k = 40
+    }
+    r.Rating += k * (score - expected(r.Rating, opp))
+    r.Games++
+}
+
+// Rating on one board, kept by the board's fingerprint so renamed and
+// duplicate board files share it
+type BoardRating struct {
+    // Name of the board when last rated
+    Board string
+    Rating
+}
+
+type Account struct {
+    Name string
+    // Hex SHA-256 of the secret
+    Secret string
+    Rating
+    Wins int
+    Losses int
+    Draws int
+    // Ratings of each board by fingerprint, see Rekey for older files
+    Boards map[string]*BoardRating
+    Created time.Time
+}
+
+// Public part of an account
+type Profile struct {
+    Name string
+    Rating
+    Wins int
+    Losses int
+    Draws int
+    Boards map[string]Rating
+    Created time.Time
+}
+
+type Standing struct {
+    Name string
+    Rating
+}
+
+// Locked after Game, see the lock order in store.go
+type AccountStore struct {
+    mutex sync.Mutex
+    // By lower case name
+    accounts map[string]*Account
+    // Saved as JSON after every change if set
+    Path string
+}
+
+var accounts = NewAccountStore()
+
+func NewAccountStore() *AccountStore {
+    return &AccountStore{accounts: make(map[string]*Account)}
+}
+
+func hashSecret(secret string) string {
+    sum := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(sum[:])
+}
+
+// Letters, digits, spaces, - and _
+func ValidName(name string) error {
+    if name == "" || len(name) > MaxNameLength {
+        return errors.New("names need 1 to 20 characters")
+    }
+    if strings.TrimSpace(name) != name {
+        return errors.New("names can't start or end with a space")
+    }
+    for _,r := range name {
+        if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+            return errors.New("names only have letters, digits, spaces, - and _")
+        }
+    }
+    return nil
+}
+
+// Reads Path, a missing file is an empty store
+func (s *AccountStore) Load() error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    dat, err := os.ReadFile(s.Path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    list := make([]*Account, 0)
+    err = json.Unmarshal(dat, &list)
+    if err != nil {
+        return err
+    }
+    for _,a := range list {
+        if a.Boards == nil {
+            a.Boards = make(map[string]*BoardRating)
+        }
+        s.accounts[strings.ToLower(a.Name)] = a
+    }
+    return nil
+}
+
+// Needs the store locked, errors are only logged
+func (s *AccountStore) save() {
+    if s.Path == "" {
+        return
+    }
+    list := make([]*Account, 0, len(s.accounts))
+    for _,a := range s.accounts {
+        list = append(list, a)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Name < list[j].Name
+    })
+    jsn, err := json.Marshal(list)
+    if err == nil {
+        err = os.WriteFile(s.Path + ".tmp", jsn, 0600)
+    }
+    if err == nil {
+        err = os.Rename(s.Path + ".tmp", s.Path)
+    }
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+// Returns the secret to log in with
+func (s *AccountStore) Register(name string) (string, error) {
+    err := ValidName(name)
+    if err != nil {
+        return "", Fail(ErrAccount, err.Error())
+    }
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if _, ok := s.accounts[strings.ToLower(name)]; ok {
+        return "", Fail(ErrAccount, "name " + name + " is taken")
+    }
+    secret := NewToken()
+    s.accounts[strings.ToLower(name)] = &Account{
+        Name: name,
+        Secret: hashSecret(secret),
+        Rating: Rating{Rating: InitialRating},
+        Boards: make(map[string]*BoardRating),
+        Created: time.Now(),
+    }
+    s.save()
+    return secret, nil
+}
+
+// Returns the name as registered, or an error if name and secret don't match
+func (s *AccountStore) Login(name string, secret string) (string, error) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    a, ok := s.accounts[strings.ToLower(name)]
+    if !ok || subtle.ConstantTimeCompare([]byte(a.Secret), []byte(hashSecret(secret))) != 1 {
+        return "", Fail(ErrAccount, "wrong name or secret")
+    }
+    return a.Name, nil
+}
+
+// Black's score is 1, 0.5 or 0, unknown names are ignored
+func (s *AccountStore) RateGame(black string, white string, fingerprint string, boardName string, score float64) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    a, aok := s.accounts[strings.ToLower(black)]
+    b, bok := s.accounts[strings.ToLower(white)]
+    if !aok || !bok || a == b {
+        return
+    }
+    ra, rb := a.Rating.Rating, b.Rating.Rating
+    a.update(rb, score)
+    b.update(ra, 1-score)
+    for _,acct := range []*Account{a, b} {
+        if acct.Boards[fingerprint] == nil {
+            acct.Boards[fingerprint] = &BoardRating{Rating: Rating{Rating: InitialRating}}
+        }
+        acct.Boards[fingerprint].Board = boardName
+    }
+    ba, bb := &a.Boards[fingerprint].Rating, &b.Boards[fingerprint].Rating
+    ra, rb = ba.Rating, bb.Rating
+    ba.update(rb, score)
+    bb.update(ra, 1-score)
+    switch score {
+    case 1:
+        a.Wins++
+        b.Losses++
+    case 0:
+        a.Losses++
+        b.Wins++
+    default:
+        a.Draws++
+        b.Draws++
+    }
+    s.save()
+}
+
+// Top n by overall rating, or by the rating of a board if fingerprint is set
+func (s *AccountStore) Leaderboard(fingerprint string, n int) []Standing {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    list := make([]Standing, 0)
+    for _,a := range s.accounts {
+        r := a.Rating
+        if fingerprint != "" {
+            if a.Boards[fingerprint] == nil {
+                continue
+            }
+            r = a.Boards[fingerprint].Rating
+        }
+        if r.Games > 0 {
+            list = append(list, Standing{Name: a.Name, Rating: r})
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].Rating.Rating != list[j].Rating.Rating {
+            return list[i].Rating.Rating > list[j].Rating.Rating
+        }
+        return list[i].Name < list[j].Name
+    })
+    if len(list) > n {
+        list = list[:n]
+    }
+    return list
+}
+
+// nil if there is no such account
+func (s *AccountStore) Profile(name string) *Profile {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    a, ok := s.accounts[strings.ToLower(name)]
+    if !ok {
+        return nil
+    }
+    p := &Profile{Name: a.Name, Rating: a.Rating, Wins: a.Wins, Losses: a.Losses, Draws: a.Draws, Boards: make(map[string]Rating), Created: a.Created}
+    // By board name, a name rated under two fingerprints shows the one
+    // with more games
+    for _,r := range a.Boards {
+        if old, ok := p.Boards[r.Board]; !ok || r.Games > old.Games {
+            p.Boards[r.Board] = r.Rating
+        }
+    }
+    return p
+}
+
+func isFingerprint(key string) bool {
+    if len(key) != ai.FingerprintLen {
+        return false
+    }
+    _, err := hex.DecodeString(key)
+    return err == nil
+}
+
+// Moves board ratings kept by board name, as in older files, to the
+// board's fingerprint, boards that can't be found keep their name
+func (s *AccountStore) Rekey(fingerprint func(name string) (string, error)) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    prints := make(map[string]string)
+    changed := false
+    for _,a := range s.accounts {
+        for key,r := range a.Boards {
+            if isFingerprint(key) {
+                continue
+            }
+            fp, ok := prints[key]
+            if !ok {
+                var err error
+                fp, err = fingerprint(key)
+                if err != nil {
+                    log.Println(err)
+                }
+                prints[key] = fp
+            }
+            if fp == "" {
+                continue
+            }
+            r.Board = key
+            if old := a.Boards[fp]; old == nil || r.Games > old.Games {
+                a.Boards[fp] = r
+            }
+            delete(a.Boards, key)
+            changed = true
+        }
+    }
+    if changed {
+        s.save()
+    }
+}
+
+// Needs the game locked
+// Two player games between different accounts are rated once, when they end
+// with a result, expired and abandoned games are not
+func (game *Game) Rate() {
+    if game.AIGame || game.Rated || game.Names[0] == "" || game.Names[1] == "" {
+        return
+    }
+    var score float64
+    switch {
+    case game.Loser != -1:
+        score = float64(game.Loser)
+    case game.Board.GameOver():
+        scores := game.Board.GetScores()
+        score = 0.5
+        if scores[0] > scores[1] {
+            score = 1
+        } else if scores[0] < scores[1] {
+            score = 0
+        }
+    default:
+        return
+    }
+    game.Rated = true
+    accounts.RateGame(game.Names[0], game.Names[1], game.Fingerprint, game.BoardName, score)
+}
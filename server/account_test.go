@@ -0,0 +1,126 @@
+package main
+
+import (
+    "errors"
+    "math"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+    "github.com/gorilla/websocket"
+)
+
+func TestRatings(t *testing.T) {
+    s := NewAccountStore()
+    s.Path = filepath.Join(t.TempDir(), "accounts.json")
+    secret, err := s.Register("alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.Register("Alice"); err == nil {
+        t.Fatal("name taken twice")
+    }
+    if _, err := s.Register(" bob"); err == nil {
+        t.Fatal("bad name accepted")
+    }
+    s.Register("bob")
+    if name, err := s.Login("ALICE", secret); err != nil || name != "alice" {
+        t.Fatal("login", name, err)
+    }
+    if _, err := s.Login("alice", secret + "x"); err == nil {
+        t.Fatal("wrong secret accepted")
+    }
+
+    s.RateGame("alice", "bob", "fp1", "b1", 1)
+    alice, bob := s.Profile("alice"), s.Profile("bob")
+    if alice.Rating.Rating != 1520 || bob.Rating.Rating != 1480 || alice.Wins != 1 || bob.Losses != 1 {
+        t.Fatalf("ratings %v %v", alice.Rating, bob.Rating)
+    }
+    s.RateGame("alice", "bob", "fp2", "b2", 0.5)
+    alice = s.Profile("alice")
+    if alice.Boards["b1"].Rating != 1520 || alice.Boards["b2"].Rating != 1500 || alice.Draws != 1 {
+        t.Fatalf("board ratings %v", alice.Boards)
+    }
+    if math.Abs(alice.Rating.Rating + s.Profile("bob").Rating.Rating - 3000) > 1e-9 {
+        t.Fatal("ratings not zero sum")
+    }
+    if lb := s.Leaderboard("", 10); len(lb) != 2 || lb[0].Name != "alice" {
+        t.Fatalf("leaderboard %v", lb)
+    }
+    if lb := s.Leaderboard("fp3", 10); len(lb) != 0 {
+        t.Fatalf("board leaderboard %v", lb)
+    }
+
+    loaded := NewAccountStore()
+    loaded.Path = s.Path
+    if err := loaded.Load(); err != nil {
+        t.Fatal(err)
+    }
+    if p := loaded.Profile("bob"); p == nil || p.Rating != s.Profile("bob").Rating {
+        t.Fatal("accounts not saved")
+    }
+    if _, err := loaded.Login("alice", secret); err != nil {
+        t.Fatal(err)
+    }
+
+    // Older files keep board ratings by name
+    fp := strings.Repeat("ab", ai.FingerprintLen/2)
+    loaded.accounts["alice"].Boards["old board"] = &BoardRating{Rating: Rating{1600, 3}}
+    loaded.Rekey(func(name string) (string, error) {
+        if name != "old board" {
+            return "", errors.New("no board " + name)
+        }
+        return fp, nil
+    })
+    if lb := loaded.Leaderboard(fp, 10); len(lb) != 1 || lb[0].Rating.Rating != 1600 {
+        t.Fatalf("rekeyed leaderboard %v", lb)
+    }
+    if p := loaded.Profile("alice"); p.Boards["old board"].Games != 3 || p.Boards["b1"].Games != 1 {
+        t.Fatalf("rekeyed profile %v", p.Boards)
+    }
+}
+
+// A two player game between accounts is rated when one concedes
+func TestRatedGame(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    conns := make([]*websocket.Conn, 2)
+    for i,name := range []string{"alice", "bob"} {
+        conn, err := dial(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.WriteJSON(Request{Action: "Register", Name: name})
+        if reply, err := read(conn); err != nil || reply.Name != name || reply.Secret == "" {
+            t.Fatal("register", reply.Error, err)
+        }
+        conns[i] = conn
+    }
+    conns[0].WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+    reply, err := read(conns[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    conns[1].WriteJSON(Request{Action: "JoinGame", Key: reply.Key})
+    joined, err := read(conns[1])
+    if err != nil || len(joined.Names) != 2 || joined.Names[0] != "alice" || joined.Names[1] != "bob" {
+        t.Fatal("names", joined.Names, err)
+    }
+    conns[1].WriteJSON(Request{Action: "Concede", Key: reply.Key})
+    if end, err := read(conns[0]); err != nil || end.Action != "Concede" {
+        t.Fatal(end.Action, err)
+    }
+
+    conns[0].WriteJSON(Request{Action: "Leaderboard", BoardName: testBoard})
+    lb, err := read(conns[0])
+    if err != nil || len(lb.Leaderboard) != 2 || lb.Leaderboard[0].Name != "alice" || lb.Leaderboard[0].Rating.Rating <= InitialRating {
+        t.Fatal("leaderboard", lb.Leaderboard, err)
+    }
+    conns[0].WriteJSON(Request{Action: "Profile", Name: "bob"})
+    profile, err := read(conns[0])
+    if err != nil || profile.Profile == nil || profile.Profile.Losses != 1 || profile.Profile.Boards[testBoard].Games != 1 {
+        t.Fatal("profile", profile.Profile, err)
+    }
+}
@@ -1,39 +1,106 @@
 package ai
 
 import (
+    "context"
     //"fmt"
     "math"
     //"sort"
     "time"
 )
 
-func Loop(me int, board *Board, inChan chan bool, outChan chan bool, depth int, timeMillis int) {
+// One table for the whole game, made here if ab has none
+// ab.Solved is set after each move, read it once the move is on outChan
+func Loop(ctx context.Context, me int, board *Board, inChan chan bool, outChan chan bool, ab *AlphaBeta) {
+    if ab.Table == nil {
+        ab.Table = NewTranspositionTable(TableSize)
+    }
+    LoopEngine(ctx, me, board, inChan, outChan, ab)
+}
+
+// Engine keeps its state, e.g. tables or trees, for the whole game
+// Returns when ctx is done, cancelling any search
+func LoopEngine(ctx context.Context, me int, board *Board, inChan chan bool, outChan chan bool, engine Engine) {
     for { 
-        state := <- inChan 
+        var state bool
+        select {
+        case state = <- inChan:
+        case <-ctx.Done():
+            return
+        }
         // false state indicates player disconnect
         // concession or two passes
         if !state || board.GameOver() {
             break
         }
-        next := Search(board.Clone(), me, depth, timeMillis)
+        next := engine.Search(ctx, board.Clone(), me)
+        if ctx.Err() != nil {
+            return
+        }
         if next == nil {
             time.Sleep(100 * time.Millisecond)
             continue
         }
         *board = *next
-        outChan <- true
+        select {
+        case outChan <- true:
+        case <-ctx.Done():
+            return
+        }
     }
 }
 
 // Set up iterative deepening
-func Search(board *Board, me int, depth int, timeMillis int) *Board {
+func Search(ctx context.Context, board *Board, me int, depth int, timeMillis int) *Board {
+    ab := &AlphaBeta{
+        Depth: depth,
+        TimeMillis: timeMillis,
+        Eval: DefaultEvaluator,
+        Table: NewTranspositionTable(TableSize),
+        Endgame: EndgameEmpties,
+    }
+    return ab.Search(ctx, board, me)
+}
+
+// Iterative deepening settings kept between moves
+// Earlier iterations and searches leave their best moves in Table
+// Eval and Table may be nil
+// With Endgame empties or fewer the game is solved exactly, falling back
+// to iterative deepening if that takes over half the time
+type AlphaBeta struct {
+    Depth int
+    TimeMillis int
+    Eval Evaluator
+    Table *TranspositionTable
+    Endgame int
+    // Result of the last Search if it solved the game, else nil
+    Solved *EndgameResult
+}
+
+func (ab *AlphaBeta) SetTimeMillis(timeMillis int) {
+    ab.TimeMillis = timeMillis
+}
+
+func (ab *AlphaBeta) Search(ctx context.Context, board *Board, me int) *Board {
+    depth := ab.Depth
+    timeMillis := ab.TimeMillis
     if board.Turn % 2 != me {
         return nil
     }
     startTime := time.Now()
+    // Build once so clones share it
+    board.Geometry()
+    ab.Solved = nil
+    if board.Empties() <= ab.Endgame && !board.GameOver() {
+        if r, ok := Solve(ctx, board, SolveExact, timeMillis/2); ok {
+            ab.Solved = &r
+            res := board.Clone()
+            res.MakeMove(r.Move)
+            return res
+        }
+    }
     var res *Board
     for d := 1; d < depth; d++ {
-        _, fn, fin, _ := SearchDeepAlphaBeta(board.Clone(), me, d, math.Inf(-1), math.Inf(1), true, startTime, timeMillis)
+        _, fn, fin, _ := SearchDeepAlphaBeta(ctx, board.Clone(), me, d, math.Inf(-1), math.Inf(1), true, startTime, timeMillis, ab.Table, ab.Eval)
         if fn != nil && fin {
             res = fn()
         } else {
@@ -57,62 +124,166 @@ func min(a float64, b float64) float64 {
     return b
 }
 
-func SearchDeepAlphaBeta(board *Board, me int, depth int, alpha float64, beta float64, maxNotMin bool, startTime time.Time, timeMillis int) (*Board, func()*Board, bool, float64) {
+// Search state shared by all nodes
+type searcher struct {
+    ctx context.Context
+    geo *Geometry
+    me int
+    startTime time.Time
+    timeMillis int
+    nodes int
+    stopped bool
+    // Move lists by remaining depth
+    moves [][]int
+    // May be nil
+    tt *TranspositionTable
+    eval Evaluator
+}
+
+// Disc count if eval is nil
+func newSearcher(ctx context.Context, geo *Geometry, me int, depth int, startTime time.Time, timeMillis int, tt *TranspositionTable, eval Evaluator) *searcher {
+    if eval == nil {
+        eval = DiscCount{}
+    }
+    return &searcher{
+        ctx: ctx,
+        geo: geo,
+        me: me,
+        tt: tt,
+        eval: eval,
+        startTime: startTime,
+        timeMillis: timeMillis,
+        moves: make([][]int, depth+1),
+    }
+}
+
+// Checking the clock and context is slow compared to a node
+func (s *searcher) timeUp() bool {
+    if s.nodes % 256 == 0 && (time.Since(s.startTime).Milliseconds() > int64(s.timeMillis) || s.ctx.Err() != nil) {
+        s.stopped = true
+    }
+    return s.stopped
+}
+
+// Best move at leaves, when the search stops and when the game is over
+const NoMove = -2
+
+// Values depend on the searching player
+func (s *searcher) key(pos *Position) uint64 {
+    key := pos.Hash()
+    if s.me == 1 {
+        key ^= zobristMe
+    }
+    return key
+}
+
+// Returns best move (NoMove at leaves), whether the search finished, and value
+// A player with no moves passes, the game ends when neither can move
+func (s *searcher) alphaBeta(pos *Position, depth int, alpha float64, beta float64, maxNotMin bool) (int, bool, float64) {
+    s.nodes++
     if depth == 0 {
-        return board, nil, true, board.Eval(me)
+        return NoMove, true, s.eval.Eval(s.geo, pos, s.me)
     }
-    if time.Since(startTime).Milliseconds() > int64(timeMillis) {
-        return nil, nil, false, 0
+    if s.timeUp() {
+        return NoMove, false, 0
     }
-    fns := board.GetCandidates()
-    if len(fns) == 0 {
-        /*var val float64
-        if maxNotMin {
-            val = math.Inf(-1)
-        } else {
-            val = math.Inf(1)
+    var key uint64
+    ttMove := NoMove
+    if s.tt != nil {
+        key = s.key(pos)
+        if e, ok := s.tt.Probe(key); ok {
+            if int(e.Depth) >= depth {
+                if e.Bound == Exact ||
+                    (e.Bound == Lower && e.Value >= beta) ||
+                    (e.Bound == Upper && e.Value <= alpha) {
+                    return int(e.Move), true, e.Value
+                }
+            }
+            ttMove = int(e.Move)
+        }
+    }
+    moves := s.geo.Moves(pos, s.moves[depth][:0])
+    s.moves[depth] = moves
+    if len(moves) == 0 {
+        if !s.geo.hasMovesFor(pos, 1 - pos.Turn % 2) {
+            return NoMove, true, finalScore(pos, s.me)
+        }
+        moves = append(moves, Pass)
+    }
+    // Best move from an earlier search first
+    for i,move := range moves {
+        if move == ttMove {
+            moves[0], moves[i] = moves[i], moves[0]
+            break
         }
-        return nil, nil, true, val*/
-        return nil, nil, true, board.Eval(me)
     }
+    alpha0, beta0 := alpha, beta
     var v float64
-    var resBoard *Board
-    var resFn func()*Board
+    best := -1
     if maxNotMin {
         v = math.Inf(-1)
     } else {
         v = math.Inf(1)
     }
-    for _,fn := range fns {
-        next := fn()
-        if next.GameOver() {
-            return next, fn, true, next.Eval(me)
-        }
-        n, _, fin, val := SearchDeepAlphaBeta(next, me, depth-1, alpha, beta, !maxNotMin, startTime, timeMillis) 
+    for _,move := range moves {
+        next := *pos
+        s.geo.MakeMove(&next, move)
+        _, fin, val := s.alphaBeta(&next, depth-1, alpha, beta, !maxNotMin)
         if !fin {
-            return nil, nil, false, 0
+            return NoMove, false, 0
         }
         if maxNotMin {
             if val > v {
                 v = val
-                resBoard = n
-                resFn = fn
+                best = move
                 alpha = max(alpha, v)
             }
             if v >= beta {
-                return resBoard, resFn, true, v
+                break
             }
         } else {
             if val < v {
                 v = val
-                resBoard = n
-                resFn = fn
+                best = move
                 beta = min(beta, v)
             }
             if v <= alpha {
-                return resBoard, resFn, true, v
+                break
             }
         }
     }
-    return resBoard, resFn, true, v
+    if s.tt != nil {
+        bound := Exact
+        if v <= alpha0 {
+            bound = Upper
+        } else if v >= beta0 {
+            bound = Lower
+        }
+        s.tt.Store(key, depth, bound, v, best)
+    }
+    return best, true, v
+}
+
+// Searches on a Position and returns the board after the best move
+// and a function that makes it
+// tt and eval may be nil
+func SearchDeepAlphaBeta(ctx context.Context, board *Board, me int, depth int, alpha float64, beta float64, maxNotMin bool, startTime time.Time, timeMillis int, tt *TranspositionTable, eval Evaluator) (*Board, func()*Board, bool, float64) {
+    s := newSearcher(ctx, board.Geometry(), me, depth, startTime, timeMillis, tt, eval)
+    pos := board.Position()
+    move, fin, val := s.alphaBeta(&pos, depth, alpha, beta, maxNotMin)
+    if !fin {
+        return nil, nil, false, 0
+    }
+    if depth == 0 {
+        return board, nil, true, val
+    }
+    if move == NoMove {
+        return nil, nil, true, val
+    }
+    fn := func() *Board {
+        b := board.Clone()
+        b.MakeMove(move)
+        return b
+    }
+    return fn(), fn, true, val
 }
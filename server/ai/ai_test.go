@@ -1,9 +1,11 @@
 package ai
 
 import (
+    "context"
     "fmt"
     "math"
     "testing"
+    "time"
 )
 
 func TestMakeTraditional(t *testing.T) {
@@ -82,6 +84,49 @@ func TestGetCandidates(t *testing.T) {
     }
 }
 
+// White has no moves, black does
+func passBoard() *Board {
+    board := MakeTraditional(4)
+    board.Premove(0, 0)
+    board.Premove(1, 1)
+    board.Turn = 1
+    return board
+}
+
+func TestPass(t *testing.T) {
+    board := passBoard()
+    if !board.MustPass() || board.GameOver() {
+        t.Errorf("got %v %v, expect %v %v", board.MustPass(), board.GameOver(), true, false)
+    }
+    if !board.MoveIsLegal(Pass) || board.MoveIsLegal(2) {
+        t.Errorf("expect only Pass legal")
+    }
+    cand := board.GetCandidates()
+    if len(cand) != 1 || cand[0]().Turn != 2 {
+        t.Errorf("expect a single pass candidate")
+    }
+    board.MakeMove(Pass)
+    if board.Turn != 2 || board.MustPass() || board.MoveIsLegal(Pass) {
+        t.Errorf("black should move after pass")
+    }
+    board.MakeMove(2)
+    if !board.GameOver() || board.MustPass() {
+        t.Errorf("got %v, expect game over", board.GetPossibleMoves())
+    }
+}
+
+func TestSearchPass(t *testing.T) {
+    board := passBoard()
+    next := Search(context.Background(), board, 1, 4, 1000)
+    if next == nil || next.Turn != 2 || !Equals(next.Points, board.Points) {
+        t.Fatalf("expect AI to pass")
+    }
+    next = Search(context.Background(), next, 0, 4, 1000)
+    if next == nil || next.Points[2].Player != 0 {
+        t.Errorf("expect black to play 2")
+    }
+}
+
 /*func TestGetCandidates2(t *testing.T) {
     board := MakeTraditional(4)
     board.Premove(4, 1)
@@ -142,3 +187,228 @@ func TestPointsToLinesGood(t *testing.T) {
     }
     fmt.Println(lines)
 }
+
+// GetPossibleMoves before the line index
+func scanPossibleMoves(board *Board) []int {
+    me := board.Turn % 2
+    moves := []int{}
+    for _,line := range board.Lines {
+        for i,pId := range line.Ids {
+            if board.Points[pId].Player != -1 || Includes(moves, pId) {
+                continue
+            }
+            if board.CaptureBackwards(line.Ids, i-1, me, false) || 
+                board.CaptureForwards(line.Ids, i+1, me, false) {
+                moves = append(moves, pId)
+            }
+        }
+    }
+    return moves
+}
+
+func TestLineIndexMatchesScan(t *testing.T) {
+    plan, _, err := MakeFromPlan(loadTestPlan(t, "David's Original Board"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    plan.PlaceStart()
+    trad := MakeTraditional(8)
+    trad.Premove(27, 1)
+    trad.Premove(28, 0)
+    trad.Premove(35, 0)
+    trad.Premove(36, 1)
+    for _,board := range []*Board{trad, plan} {
+        for i := 0; ; i++ {
+            got := board.GetPossibleMoves()
+            expect := scanPossibleMoves(board)
+            if len(got) != len(expect) || !IsSubset(got, expect) {
+                t.Fatalf("got %v, expect %v", got, expect)
+            }
+            if len(got) == 0 {
+                break
+            }
+            board.MakeMove(got[(i*7) % len(got)])
+        }
+    }
+}
+
+var benchBoards = make(map[string]*Board)
+
+func benchBoard(b testing.TB, name string) *Board {
+    if board, ok := benchBoards[name]; ok {
+        return board
+    }
+    var board *Board
+    if name == "" {
+        board = MakeTraditional(8)
+        board.Premove(27, 1)
+        board.Premove(28, 0)
+        board.Premove(35, 0)
+        board.Premove(36, 1)
+    } else {
+        var err error
+        board, _, err = MakeFromPlan(loadTestPlan(b, name))
+        if err != nil {
+            b.Fatal(err)
+        }
+        board.PlaceStart()
+    }
+    benchBoards[name] = board
+    return board
+}
+
+// Flips are exactly the stones that change color
+func TestFlips(t *testing.T) {
+    for _,name := range []string{"", "tripools with hexcenters", "A nice little board"} {
+        board := benchBoard(t, name).Clone()
+        for i := 0; !board.GameOver(); i++ {
+            moves := board.GetPossibleMoves()
+            if len(moves) == 0 {
+                board.MakeMove(Pass)
+                continue
+            }
+            move := moves[(i*7) % len(moves)]
+            flips := board.Flips(move)
+            prev := board.Clone()
+            board.MakeMove(move)
+            changed := 0
+            for id := range board.Points {
+                if id == move || prev.Points[id].Player == board.Points[id].Player {
+                    continue
+                }
+                changed++
+                if !Includes(flips, id) {
+                    t.Fatalf("%q move %d: %d flipped but not in %v", name, move, id, flips)
+                }
+            }
+            if changed != len(flips) {
+                t.Fatalf("%q move %d: %d flipped, reported %v", name, move, changed, flips)
+            }
+        }
+    }
+}
+
+// Play a game to the end choosing moves deterministically
+func benchPlayout(b *testing.B, name string) {
+    start := benchBoard(b, name)
+    b.ResetTimer()
+    for n := 0; n < b.N; n++ {
+        board := start.Clone()
+        for i := 0; ; i++ {
+            moves := board.GetPossibleMoves()
+            if len(moves) == 0 {
+                break
+            }
+            board.MakeMove(moves[(i*7) % len(moves)])
+        }
+    }
+}
+
+func BenchmarkPlayoutTraditional8(b *testing.B) {
+    benchPlayout(b, "")
+}
+
+// 346 points
+func BenchmarkPlayoutTripools(b *testing.B) {
+    benchPlayout(b, "tripools with hexcenters")
+}
+
+// 233 points
+func BenchmarkPlayoutNiceLittle(b *testing.B) {
+    benchPlayout(b, "A nice little board")
+}
+
+func BenchmarkGetPossibleMovesTripools(b *testing.B) {
+    board := benchBoard(b, "tripools with hexcenters")
+    b.ResetTimer()
+    for n := 0; n < b.N; n++ {
+        board.GetPossibleMoves()
+    }
+}
+
+func benchSearch(b *testing.B, name string, depth int) {
+    start := benchBoard(b, name)
+    b.ResetTimer()
+    for n := 0; n < b.N; n++ {
+        SearchDeepAlphaBeta(context.Background(), start.Clone(), 0, depth, math.Inf(-1), math.Inf(1), true, time.Now(), 1000000, nil, nil)
+    }
+}
+
+func BenchmarkSearchTraditional8(b *testing.B) {
+    benchSearch(b, "", 6)
+}
+
+func BenchmarkSearchTripools(b *testing.B) {
+    benchSearch(b, "tripools with hexcenters", 4)
+}
+
+func TestSearchCancel(t *testing.T) {
+    board := benchBoard(t, "")
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        time.Sleep(50 * time.Millisecond)
+        cancel()
+    }()
+    start := time.Now()
+    ab := &AlphaBeta{Depth: 100, TimeMillis: 100000, Eval: DefaultEvaluator}
+    ab.Search(ctx, board.Clone(), 0)
+    m := &MCTS{TimeMillis: 100000}
+    m.Search(ctx, board.Clone(), 0)
+    if d := time.Since(start); d > time.Second {
+        t.Errorf("searches took %v after cancel", d)
+    }
+}
+
+func TestLoopExits(t *testing.T) {
+    board := benchBoard(t, "").Clone()
+    ctx, cancel := context.WithCancel(context.Background())
+    inChan := make(chan bool)
+    outChan := make(chan bool)
+    done := make(chan bool)
+    go func() {
+        Loop(ctx, 0, board, inChan, outChan, &AlphaBeta{Depth: 100, TimeMillis: 100000, Eval: DefaultEvaluator})
+        done <- true
+    }()
+    // Cancel mid search
+    inChan <- true
+    time.Sleep(50 * time.Millisecond)
+    cancel()
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+        t.Fatalf("Loop did not exit")
+    }
+}
+
+type firstMove struct{}
+
+func (firstMove) Search(ctx context.Context, board *Board, me int) *Board {
+    next := board.Clone()
+    next.MakeMove(board.GetPossibleMoves()[0])
+    return next
+}
+
+func TestBlunder(t *testing.T) {
+    board := MakeTraditional(8)
+    board.Premove(27, 1)
+    board.Premove(28, 0)
+    board.Premove(35, 0)
+    board.Premove(36, 1)
+    moves := board.GetPossibleMoves()
+    never := &Blunder{Engine: firstMove{}, Rate: 0}
+    always := &Blunder{Engine: firstMove{}, Rate: 1, Seed: 1}
+    seen := make(map[uint64]bool)
+    for i := 0; i < 20; i++ {
+        if next := never.Search(context.Background(), board.Clone(), 0); next.Points[moves[0]].Player != 0 {
+            t.Fatal("rate 0 didn't use the engine")
+        }
+        next := always.Search(context.Background(), board.Clone(), 0)
+        if next.Turn != 1 {
+            t.Fatal("no move")
+        }
+        seen[next.Hash()] = true
+    }
+    if len(seen) < 2 {
+        t.Errorf("rate 1 played %d different moves", len(seen))
+    }
+}
@@ -0,0 +1,334 @@
+package ai
+
+import (
+    "math/bits"
+    "sort"
+)
+
+// Compact positions for the search
+// A Position is just the stones of each player and the turn, everything
+// else about the board is precomputed once in a Geometry
+
+const bitsetWords = MaxPoints/64
+
+// Set of point ids
+type Bitset [bitsetWords]uint64
+
+func (b *Bitset) Has(i int) bool {
+    return b[i>>6] & (1 << uint(i&63)) != 0
+}
+
+func (b *Bitset) Set(i int) {
+    b[i>>6] |= 1 << uint(i&63)
+}
+
+func (b *Bitset) Unset(i int) {
+    b[i>>6] &^= 1 << uint(i&63)
+}
+
+func (b *Bitset) Or(c *Bitset) {
+    for i := range b {
+        b[i] |= c[i]
+    }
+}
+
+func (b *Bitset) AndNot(c *Bitset) {
+    for i := range b {
+        b[i] &^= c[i]
+    }
+}
+
+func (b *Bitset) Intersects(c *Bitset) bool {
+    for i := range b {
+        if b[i] & c[i] != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+func (b *Bitset) Count() int {
+    n := 0
+    for _,w := range b {
+        n += bits.OnesCount64(w)
+    }
+    return n
+}
+
+func (b *Bitset) Ids() []int {
+    ids := make([]int, 0)
+    for i,w := range b {
+        for w != 0 {
+            ids = append(ids, i*64 + bits.TrailingZeros64(w))
+            w &= w-1
+        }
+    }
+    return ids
+}
+
+type Position struct {
+    Stones [2]Bitset
+    Turn int
+    // Zobrist key of the stones, see Hash
+    StoneHash uint64
+}
+
+// Points after a move in one direction of a line
+type Ray struct {
+    Line int
+    Ids []int
+}
+
+type Geometry struct {
+    N int
+    // Words of a Bitset in use
+    Words int
+    Rays [][]Ray
+    // First point of every ray out of a point
+    Adjacent []Bitset
+    LineMasks []Bitset
+    All Bitset
+    // Points least likely to be flipped, see makeCorners
+    Corners Bitset
+    lines []Line
+    index LineIndex
+}
+
+func rayLess(a []int, b []int) bool {
+    for i := 0; i < len(a) && i < len(b); i++ {
+        if a[i] != b[i] {
+            return a[i] < b[i]
+        }
+    }
+    return len(a) < len(b)
+}
+
+func rayIsPrefix(a []int, b []int) bool {
+    return len(a) <= len(b) && Equals(a, b[:len(a)])
+}
+
+// Rays shorter than two points can't capture
+// Bifurcating lines give rays that are prefixes of others, these capture
+// nothing the longer ray doesn't and are dropped
+func makeRays(index LineIndex, lines []Line, id int) []Ray {
+    rays := make([]Ray, 0)
+    for _,lp := range index[id] {
+        ids := lines[lp.Line].Ids
+        if lp.Pos > 1 {
+            bwd := make([]int, lp.Pos)
+            copy(bwd, ids[:lp.Pos])
+            Reverse(bwd)
+            rays = append(rays, Ray{lp.Line, bwd})
+        }
+        if lp.Pos < len(ids)-2 {
+            fwd := make([]int, len(ids)-lp.Pos-1)
+            copy(fwd, ids[lp.Pos+1:])
+            rays = append(rays, Ray{lp.Line, fwd})
+        }
+    }
+    sort.Slice(rays, func(i, j int) bool {
+        return rayLess(rays[i].Ids, rays[j].Ids)
+    })
+    keep := make([]Ray, 0, len(rays))
+    for i,ray := range rays {
+        if i < len(rays)-1 && rayIsPrefix(ray.Ids, rays[i+1].Ids) {
+            continue
+        }
+        keep = append(keep, ray)
+    }
+    return keep
+}
+
+// Boards are limited to MaxPoints
+func MakeGeometry(n int, lines []Line, index LineIndex) *Geometry {
+    if n > MaxPoints {
+        panic("ai: board has more than MaxPoints points")
+    }
+    geo := &Geometry{
+        N: n,
+        Words: (n+63)/64,
+        Rays: make([][]Ray, n),
+        Adjacent: make([]Bitset, n),
+        LineMasks: make([]Bitset, len(lines)),
+        lines: lines,
+        index: index,
+    }
+    for i := 0; i < n; i++ {
+        geo.All.Set(i)
+        geo.Rays[i] = makeRays(index, lines, i)
+        for _,ray := range geo.Rays[i] {
+            geo.Adjacent[i].Set(ray.Ids[0])
+        }
+    }
+    for j,line := range lines {
+        for _,id := range line.Ids {
+            geo.LineMasks[j].Set(id)
+        }
+    }
+    geo.Corners = makeCorners(n, lines, index, geo.Adjacent)
+    return geo
+}
+
+// Shared by all clones like the line index
+func (board *Board) Geometry() *Geometry {
+    if board.geo == nil {
+        board.geo = MakeGeometry(len(board.Points), board.Lines, board.lineIndex())
+    }
+    return board.geo
+}
+
+func (board *Board) Position() Position {
+    pos := Position{Turn: board.Turn, StoneHash: board.stoneHash()}
+    for i,p := range board.Points {
+        if p.Player == 0 || p.Player == 1 {
+            pos.Stones[p.Player].Set(i)
+        }
+    }
+    return pos
+}
+
+func (board *Board) SetPosition(pos *Position) {
+    for i := range board.Points {
+        if pos.Stones[0].Has(i) {
+            board.Points[i].Player = 0
+        } else if pos.Stones[1].Has(i) {
+            board.Points[i].Player = 1
+        } else {
+            board.Points[i].Player = -1
+        }
+    }
+    board.Turn = pos.Turn
+    board.hash = pos.StoneHash
+    board.hashed = true
+}
+
+// Intersects for the words in use only, the hot path of move generation
+func (geo *Geometry) intersects(a *Bitset, b *Bitset) bool {
+    for i := 0; i < geo.Words; i++ {
+        if a[i] & b[i] != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+func (pos *Position) Empty(geo *Geometry) Bitset {
+    empty := geo.All
+    empty.AndNot(&pos.Stones[0])
+    empty.AndNot(&pos.Stones[1])
+    return empty
+}
+
+// Stones of the other player captured by a ray
+// Same rules as CaptureBackwards and CaptureForwards
+func (geo *Geometry) rayFlips(pos *Position, ray *Ray, me int, flips *Bitset) bool {
+    if !geo.intersects(&geo.LineMasks[ray.Line], &pos.Stones[me]) {
+        return false
+    }
+    opp := &pos.Stones[1-me]
+    for i,id := range ray.Ids {
+        if opp.Has(id) {
+            continue
+        }
+        if i == 0 || !pos.Stones[me].Has(id) {
+            return false
+        }
+        if flips != nil {
+            for _,f := range ray.Ids[:i] {
+                flips.Set(f)
+            }
+        }
+        return true
+    }
+    return false
+}
+
+func (geo *Geometry) IsLegal(pos *Position, to int) bool {
+    return geo.isLegalFor(pos, to, pos.Turn % 2)
+}
+
+func (geo *Geometry) isLegalFor(pos *Position, to int, me int) bool {
+    if to < 0 || to >= geo.N || pos.Stones[0].Has(to) || pos.Stones[1].Has(to) {
+        return false
+    }
+    if !geo.intersects(&geo.Adjacent[to], &pos.Stones[1-me]) {
+        return false
+    }
+    for i := range geo.Rays[to] {
+        if geo.rayFlips(pos, &geo.Rays[to][i], me, nil) {
+            return true
+        }
+    }
+    return false
+}
+
+// Legal moves in order of id, appended to moves
+func (geo *Geometry) Moves(pos *Position, moves []int) []int {
+    empty := pos.Empty(geo)
+    for w := range empty {
+        word := empty[w]
+        for word != 0 {
+            id := w*64 + bits.TrailingZeros64(word)
+            word &= word-1
+            if geo.IsLegal(pos, id) {
+                moves = append(moves, id)
+            }
+        }
+    }
+    return moves
+}
+
+func (geo *Geometry) HasMoves(pos *Position) bool {
+    return geo.hasMovesFor(pos, pos.Turn % 2)
+}
+
+func (geo *Geometry) hasMovesFor(pos *Position, me int) bool {
+    empty := pos.Empty(geo)
+    for w := range empty {
+        word := empty[w]
+        for word != 0 {
+            id := w*64 + bits.TrailingZeros64(word)
+            word &= word-1
+            if geo.isLegalFor(pos, id, me) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func (geo *Geometry) GameOver(pos *Position) bool {
+    return !geo.hasMovesFor(pos, 0) && !geo.hasMovesFor(pos, 1)
+}
+
+// Move must be legal, or Pass
+func (geo *Geometry) MakeMove(pos *Position, to int) {
+    if to == Pass {
+        pos.Turn += 1
+        return
+    }
+    me := pos.Turn % 2
+    var flips Bitset
+    for i := range geo.Rays[to] {
+        geo.rayFlips(pos, &geo.Rays[to][i], me, &flips)
+    }
+    h := pos.StoneHash ^ ZobristKey(me, to)
+    for w := 0; w < geo.Words; w++ {
+        word := flips[w]
+        for word != 0 {
+            id := w*64 + bits.TrailingZeros64(word)
+            word &= word-1
+            h ^= ZobristKey(0, id) ^ ZobristKey(1, id)
+        }
+    }
+    pos.StoneHash = h
+    flips.Set(to)
+    pos.Stones[me].Or(&flips)
+    pos.Stones[1-me].AndNot(&flips)
+    pos.Turn += 1
+}
+
+// Count number of pieces of a player
+func (pos *Position) Eval(me int) float64 {
+    return float64(pos.Stones[me].Count() - pos.Stones[1-me].Count())
+}
@@ -0,0 +1,99 @@
+package ai
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestBitset(t *testing.T) {
+    var b Bitset
+    b.Set(3)
+    b.Set(64)
+    b.Set(MaxPoints-1)
+    if !b.Has(64) || b.Has(65) || b.Count() != 3 {
+        t.Errorf("got %v", b.Ids())
+    }
+    b.Unset(64)
+    if !Equals(b.Ids(), []int{3, MaxPoints-1}) {
+        t.Errorf("got %v, expect %v", b.Ids(), []int{3, MaxPoints-1})
+    }
+}
+
+// Position and Board agree move by move
+func TestPositionMatchesBoard(t *testing.T) {
+    plan, _, err := MakeFromPlan(loadTestPlan(t, "David's Original Board"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    plan.PlaceStart()
+    for _,board := range []*Board{benchBoard(t, ""), plan} {
+        board = board.Clone()
+        geo := board.Geometry()
+        pos := board.Position()
+        for i := 0; ; i++ {
+            expect := board.GetPossibleMoves()
+            got := geo.Moves(&pos, nil)
+            if !Equals(got, expect) {
+                t.Fatalf("got %v, expect %v", got, expect)
+            }
+            if board.GameOver() != geo.GameOver(&pos) {
+                t.Fatalf("game over differs")
+            }
+            if board.GameOver() {
+                break
+            }
+            move := Pass
+            if len(got) > 0 {
+                move = got[(i*7) % len(got)]
+            }
+            board.MakeMove(move)
+            geo.MakeMove(&pos, move)
+            if pos != board.Position() {
+                t.Fatalf("move %v: positions differ", move)
+            }
+        }
+        check := board.Clone()
+        check.SetPosition(&pos)
+        if !Equals(check.Points, board.Points) || check.Turn != board.Turn {
+            t.Errorf("SetPosition does not restore board")
+        }
+    }
+}
+
+func TestSearchDeepAlphaBeta(t *testing.T) {
+    board := MakeTraditional(4)
+    board.Premove(5, 0)
+    board.Premove(6, 1)
+    board.Premove(9, 1)
+    board.Premove(10, 0)
+    next, fn, fin, _ := SearchDeepAlphaBeta(context.Background(), board, 0, 3, math.Inf(-1), math.Inf(1), true, time.Now(), 1000, nil, nil)
+    if !fin || fn == nil || next.Turn != 1 {
+        t.Fatalf("got %v %v", fin, next)
+    }
+    if !Equals(fn().Points, next.Points) {
+        t.Errorf("fn and board differ")
+    }
+}
+
+func benchNodes(b *testing.B, name string, depth int) {
+    start := benchBoard(b, name)
+    b.ResetTimer()
+    nodes := 0
+    for n := 0; n < b.N; n++ {
+        s := newSearcher(context.Background(), start.Geometry(), 0, depth, time.Now(), 1000000, nil, nil)
+        pos := start.Position()
+        s.alphaBeta(&pos, depth, math.Inf(-1), math.Inf(1), true)
+        nodes += s.nodes
+    }
+    b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+}
+
+func BenchmarkNodesTraditional8(b *testing.B) {
+    benchNodes(b, "", 6)
+}
+
+func BenchmarkNodesTripools(b *testing.B) {
+    benchNodes(b, "tripools with hexcenters", 4)
+}
@@ -0,0 +1,38 @@
+package ai
+
+import (
+    "context"
+    "math/rand"
+)
+
+// Weakens an engine for easy opponents
+// With probability Rate a random legal move is played instead of the search
+type Blunder struct {
+    Engine Engine
+    Rate float64
+    Seed int64
+    rng *rand.Rand
+}
+
+func (b *Blunder) SetTimeMillis(timeMillis int) {
+    if timed, ok := b.Engine.(Timed); ok {
+        timed.SetTimeMillis(timeMillis)
+    }
+}
+
+func (b *Blunder) Search(ctx context.Context, board *Board, me int) *Board {
+    if b.rng == nil {
+        b.rng = rand.New(rand.NewSource(b.Seed))
+    }
+    if board.Turn % 2 != me || board.GameOver() || b.rng.Float64() >= b.Rate {
+        return b.Engine.Search(ctx, board, me)
+    }
+    next := board.Clone()
+    moves := board.GetPossibleMoves()
+    if len(moves) == 0 {
+        next.MakeMove(Pass)
+    } else {
+        next.MakeMove(moves[b.rng.Intn(len(moves))])
+    }
+    return next
+}
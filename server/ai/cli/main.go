@@ -1,30 +1,154 @@
 package main
 
 import (
+    "context"
+    "flag"
     "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+
     ai "github.com/aorliche/web-nongrid-othello/ai"
 )
 
+func parseIds(s string) []int {
+    ids := make([]int, 0)
+    for _,f := range strings.Split(s, ",") {
+        if f == "" {
+            continue
+        }
+        id, err := strconv.Atoi(strings.TrimSpace(f))
+        if err != nil {
+            log.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    return ids
+}
+
+// Exits with an error if the record doesn't parse or has an illegal move
+func replayRecord(file string) {
+    f, err := os.Open(file)
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer f.Close()
+    rec, err := ai.DecodeRecord(f)
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _,t := range rec.Tags {
+        fmt.Printf("%s: %s\n", t.Name, t.Value)
+    }
+    board, err := rec.Replay()
+    if err != nil {
+        log.Fatal(err)
+    }
+    // Games on board files must still match them
+    if name := rec.Tag("Board"); name != "" {
+        b, _, err := ai.LoadPlan("../../../boards/" + name)
+        if err != nil {
+            fmt.Println("Board", name, "not checked:", err)
+        } else if err = rec.CheckBoard(b); err != nil {
+            log.Fatal(err)
+        } else {
+            fmt.Println("Board", name, "matches, fingerprint", b.Fingerprint())
+        }
+    }
+    fmt.Printf("%d points, %d lines, %d moves legal\n", len(rec.Points), len(rec.Lines), len(rec.Moves))
+    fmt.Println("Final score", board.GetScores(), "game over", board.GameOver())
+}
+
 func main() {
+    boardName := flag.String("board", "", "board plan in ../../../boards (default 4x4 traditional)")
+    black := flag.String("black", "5,10", "starting black point ids")
+    white := flag.String("white", "6,9", "starting white point ids")
+    depth := flag.Int("depth", 20, "search depth")
+    timeMillis := flag.Int("time", 5000, "search time per move in milliseconds")
+    engine := flag.String("engine", "alphabeta", "alphabeta or mcts (depth is iterations, 0 for time only)")
+    endgame := flag.Int("endgame", ai.EndgameEmpties, "solve exactly with this many empty points or fewer")
+    solve := flag.Bool("solve", false, "solve the starting position and exit")
+    wld := flag.Bool("wld", false, "with -solve, only find win, loss or draw")
+    simpleGame := flag.Bool("simple", false, "play first candidate moves and print lines")
+    replay := flag.String("replay", "", "replay a game record, checking every move")
+    flag.Parse()
+    if *simpleGame {
+        simple()
+        return
+    }
+    if *replay != "" {
+        replayRecord(*replay)
+        return
+    }
     board := ai.MakeTraditional(4)
-    board.Premove(5, 0)
-    board.Premove(6, 1)
-    board.Premove(9, 1)
-    board.Premove(10, 0)
+    traditional := *boardName == ""
+    if !traditional {
+        b, _, err := ai.LoadPlan("../../../boards/" + *boardName)
+        if err != nil {
+            log.Fatal(err)
+        }
+        board = b
+    }
+    for _,id := range parseIds(*black) {
+        board.Premove(id, 0)
+    }
+    for _,id := range parseIds(*white) {
+        board.Premove(id, 1)
+    }
+    // Interrupt stops the search
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    if *solve {
+        mode := ai.SolveExact
+        if *wld {
+            mode = ai.SolveWLD
+        }
+        r, ok := ai.Solve(ctx, board, mode, 0)
+        if ok {
+            fmt.Printf("%d empties, best move %d, disc difference %d for player %d, %d nodes\n", board.Empties(), r.Move, r.Diff, board.Turn % 2, r.Nodes)
+        }
+        return
+    }
     recvChan := make(chan bool)
     sendChans := make([]chan bool, 0)
+    searchers := make([]*ai.AlphaBeta, 2)
     for i := 0; i < 2; i++ {
         sendChans = append(sendChans, make(chan bool))
-        go ai.Loop(i, board, sendChans[i], recvChan, 20, 5000)
+        if *engine == "mcts" {
+            m := &ai.MCTS{Iterations: *depth, TimeMillis: *timeMillis, Rollout: ai.DefaultEvaluator, Seed: int64(i)}
+            go ai.LoopEngine(ctx, i, board, sendChans[i], recvChan, m)
+        } else {
+            searchers[i] = &ai.AlphaBeta{
+                Depth: *depth,
+                TimeMillis: *timeMillis,
+                Eval: ai.DefaultEvaluator,
+                Table: ai.NewTranspositionTable(ai.TableSize),
+                Endgame: *endgame,
+            }
+            go ai.LoopEngine(ctx, i, board, sendChans[i], recvChan, searchers[i])
+        }
     }
     for {
-        sendChans[board.Turn % 2] <- true
-        <-recvChan
-        fmt.Println(board.Eval(0), "-", board.Turn)
-        board.PrintTraditional()
         if board.GameOver() {
             break
         }
+        me := board.Turn % 2
+        sendChans[me] <- true
+        select {
+        case <-recvChan:
+        case <-ctx.Done():
+            fmt.Println("Interrupted", board.GetScores())
+            return
+        }
+        fmt.Println(board.Eval(0), "-", board.Turn)
+        if searchers[me] != nil && searchers[me].Solved != nil {
+            fmt.Println("Solved, disc difference", searchers[me].Solved.Diff, "for player", me)
+        }
+        if traditional {
+            board.PrintTraditional()
+        }
     }
-    fmt.Println("Game over!")
+    fmt.Println("Game over!", board.GetScores())
 }
@@ -5,7 +5,7 @@ import (
     ai "github.com/aorliche/web-nongrid-othello/ai"
 )
 
-func main() {
+func simple() {
     board := ai.MakeTraditional(4)
     board.Premove(5, 0)
     board.Premove(6, 1)
@@ -0,0 +1,158 @@
+package ai
+
+import (
+    "context"
+    "time"
+)
+
+// Exact search to the end of the game
+// Values are final disc differences for the player to move
+
+// Empties at or below which Search and Loop solve the game
+var EndgameEmpties = 12
+
+type SolveMode int
+
+const (
+    // Exact final disc difference
+    SolveExact SolveMode = iota
+    // Win, loss or draw only, much faster
+    SolveWLD
+)
+
+type EndgameResult struct {
+    // Best move for the player to move, Pass if they must pass,
+    // NoMove if the game is over
+    Move int
+    // Final disc difference for the player to move, only -1, 0 or 1
+    // with SolveWLD
+    Diff int
+    Nodes int
+}
+
+type solver struct {
+    ctx context.Context
+    geo *Geometry
+    startTime time.Time
+    timeMillis int
+    nodes int
+    stopped bool
+    // Move lists by number of empties
+    moves [][]int
+}
+
+func (s *solver) timeUp() bool {
+    if s.nodes % 256 != 0 {
+        return s.stopped
+    }
+    if (s.timeMillis > 0 && time.Since(s.startTime).Milliseconds() > int64(s.timeMillis)) || s.ctx.Err() != nil {
+        s.stopped = true
+    }
+    return s.stopped
+}
+
+// Try moves that leave the opponent the fewest replies first
+// Only worth it far from the end
+const sortEmpties = 7
+
+func (s *solver) order(pos *Position, moves []int) {
+    me := pos.Turn % 2
+    scores := make([]int, len(moves))
+    for i,move := range moves {
+        next := *pos
+        s.geo.MakeMove(&next, move)
+        scores[i] = s.geo.countMoves(&next, 1-me)
+    }
+    for i := 1; i < len(moves); i++ {
+        for j := i; j > 0 && scores[j] < scores[j-1]; j-- {
+            scores[j], scores[j-1] = scores[j-1], scores[j]
+            moves[j], moves[j-1] = moves[j-1], moves[j]
+        }
+    }
+}
+
+// Negamax, returns value, best move and whether the search finished
+func (s *solver) negamax(pos *Position, empties int, alpha int, beta int) (int, int, bool) {
+    s.nodes++
+    if s.timeUp() {
+        return 0, NoMove, false
+    }
+    me := pos.Turn % 2
+    moves := s.geo.Moves(pos, s.moves[empties][:0])
+    s.moves[empties] = moves
+    if len(moves) == 0 {
+        if !s.geo.hasMovesFor(pos, 1-me) {
+            return pos.Stones[me].Count() - pos.Stones[1-me].Count(), NoMove, true
+        }
+        next := *pos
+        s.geo.MakeMove(&next, Pass)
+        v, _, fin := s.negamax(&next, empties, -beta, -alpha)
+        return -v, Pass, fin
+    }
+    if empties > sortEmpties {
+        s.order(pos, moves)
+    }
+    best := NoMove
+    bestVal := -MaxPoints-1
+    for _,move := range moves {
+        next := *pos
+        s.geo.MakeMove(&next, move)
+        v, _, fin := s.negamax(&next, empties-1, -beta, -alpha)
+        if !fin {
+            return 0, NoMove, false
+        }
+        v = -v
+        if v > bestVal {
+            bestVal = v
+            best = move
+        }
+        if v > alpha {
+            alpha = v
+        }
+        if alpha >= beta {
+            break
+        }
+    }
+    return bestVal, best, true
+}
+
+func (board *Board) Empties() int {
+    n := 0
+    for _,p := range board.Points {
+        if p.Player == -1 {
+            n++
+        }
+    }
+    return n
+}
+
+// Solve from board for the player to move
+// Returns false if timeMillis (0 for no limit) runs out or ctx is done first
+func Solve(ctx context.Context, board *Board, mode SolveMode, timeMillis int) (EndgameResult, bool) {
+    geo := board.Geometry()
+    pos := board.Position()
+    empties := board.Empties()
+    s := &solver{
+        ctx: ctx,
+        geo: geo,
+        startTime: time.Now(),
+        timeMillis: timeMillis,
+        moves: make([][]int, empties+1),
+    }
+    alpha, beta := -MaxPoints-1, MaxPoints+1
+    if mode == SolveWLD {
+        alpha, beta = -1, 1
+    }
+    v, move, fin := s.negamax(&pos, empties, alpha, beta)
+    if !fin {
+        return EndgameResult{}, false
+    }
+    if mode == SolveWLD {
+        if v > 0 {
+            v = 1
+        } else if v < 0 {
+            v = -1
+        }
+    }
+    return EndgameResult{Move: move, Diff: v, Nodes: s.nodes}, true
+}
@@ -0,0 +1,118 @@
+package ai
+
+import (
+    "context"
+    "testing"
+)
+
+// Plain minimax on Board for the player to move
+func bruteForce(board *Board) int {
+    me := board.Turn % 2
+    moves := board.GetPossibleMoves()
+    if len(moves) == 0 {
+        if board.GameOver() {
+            return int(board.Eval(me))
+        }
+        moves = append(moves, Pass)
+    }
+    best := -MaxPoints
+    for _,move := range moves {
+        next := board.Clone()
+        next.MakeMove(move)
+        v := -bruteForce(next)
+        if v > best {
+            best = v
+        }
+    }
+    return best
+}
+
+func sign(v int) int {
+    if v > 0 {
+        return 1
+    } else if v < 0 {
+        return -1
+    }
+    return 0
+}
+
+func endgameBoards(t *testing.T) []*Board {
+    small := MakeTraditional(4)
+    small.Premove(5, 0)
+    small.Premove(10, 0)
+    small.Premove(6, 1)
+    small.Premove(9, 1)
+    // Play traditional 8x8 down to 9 empties
+    big := benchBoard(t, "").Clone()
+    for i := 0; big.Empties() > 9 && !big.GameOver(); i++ {
+        moves := big.GetPossibleMoves()
+        move := Pass
+        if len(moves) > 0 {
+            move = moves[(i*7) % len(moves)]
+        }
+        big.MakeMove(move)
+    }
+    return []*Board{small, big}
+}
+
+func TestSolve(t *testing.T) {
+    for _,board := range endgameBoards(t) {
+        expect := bruteForce(board)
+        r, ok := Solve(context.Background(), board, SolveExact, 0)
+        if !ok || r.Diff != expect {
+            t.Errorf("got %v, expect %v", r.Diff, expect)
+        }
+        next := board.Clone()
+        next.MakeMove(r.Move)
+        if got := -bruteForce(next); got != expect {
+            t.Errorf("move %v gives %v, expect %v", r.Move, got, expect)
+        }
+        wld, ok := Solve(context.Background(), board, SolveWLD, 0)
+        if !ok || wld.Diff != sign(expect) {
+            t.Errorf("got %v, expect %v", wld.Diff, sign(expect))
+        }
+    }
+}
+
+// White to move with no pieces left
+func TestSolveGameOver(t *testing.T) {
+    board := passBoard()
+    board.MakeMove(Pass)
+    board.MakeMove(2)
+    r, ok := Solve(context.Background(), board, SolveExact, 0)
+    if !ok || r.Move != NoMove || r.Diff != -3 {
+        t.Errorf("got %v", r)
+    }
+}
+
+func TestSearchSolves(t *testing.T) {
+    board := endgameBoards(t)[1]
+    me := board.Turn % 2
+    ab := &AlphaBeta{Depth: 4, TimeMillis: 10000, Endgame: 10}
+    next := ab.Search(context.Background(), board, me)
+    if ab.Solved == nil || next == nil {
+        t.Fatalf("expect endgame solved")
+    }
+    if got := -bruteForce(next); got != ab.Solved.Diff {
+        t.Errorf("got %v, expect %v", got, ab.Solved.Diff)
+    }
+}
+
+func TestLoopSolved(t *testing.T) {
+    board := endgameBoards(t)[1]
+    me := board.Turn % 2
+    ab := &AlphaBeta{Depth: 4, TimeMillis: 10000, Endgame: 10}
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    inChan := make(chan bool)
+    outChan := make(chan bool)
+    go Loop(ctx, me, board, inChan, outChan, ab)
+    inChan <- true
+    <-outChan
+    if ab.Solved == nil || ab.Table == nil {
+        t.Fatalf("expect endgame solved")
+    }
+    if got := -bruteForce(board); got != ab.Solved.Diff {
+        t.Errorf("got %v, expect %v", got, ab.Solved.Diff)
+    }
+}
@@ -0,0 +1,213 @@
+package ai
+
+import (
+    "math/bits"
+)
+
+// Evaluators score a Position for player me, higher is better for me
+type Evaluator interface {
+    Eval(geo *Geometry, pos *Position, me int) float64
+}
+
+// Score of a finished game, any win beats any evaluation
+const WinScore = 10000
+
+func finalScore(pos *Position, me int) float64 {
+    d := pos.Eval(me)
+    if d > 0 {
+        return WinScore + d
+    } else if d < 0 {
+        return -WinScore + d
+    }
+    return 0
+}
+
+// Difference in number of pieces, the original evaluation
+type DiscCount struct{}
+
+func (DiscCount) Eval(geo *Geometry, pos *Position, me int) float64 {
+    return pos.Eval(me)
+}
+
+// Each feature is my count minus the opponent's
+type Weights struct {
+    Discs float64
+    // Legal moves
+    Mobility float64
+    // Empty points next to the opponent's pieces, moves I may get later
+    Frontier float64
+    // Pieces that can't be flipped along any line
+    Stable float64
+    // Pieces on corner-like points, see Geometry.Corners
+    Corners float64
+}
+
+var DefaultWeights = Weights{
+    Discs: 1,
+    Mobility: 8,
+    Frontier: 3,
+    Stable: 12,
+    Corners: 25,
+}
+
+// Features with zero weight are not computed
+type Positional struct {
+    Weights Weights
+}
+
+func (e Positional) Eval(geo *Geometry, pos *Position, me int) float64 {
+    w := &e.Weights
+    v := 0.0
+    if w.Discs != 0 {
+        v += w.Discs * pos.Eval(me)
+    }
+    if w.Mobility != 0 {
+        v += w.Mobility * float64(geo.countMoves(pos, me) - geo.countMoves(pos, 1-me))
+    }
+    if w.Frontier != 0 {
+        mine := geo.Frontier(pos, 1-me)
+        theirs := geo.Frontier(pos, me)
+        v += w.Frontier * float64(mine.Count() - theirs.Count())
+    }
+    if w.Stable != 0 {
+        stable := geo.Stable(pos)
+        v += w.Stable * float64(countAnd(&stable, &pos.Stones[me]) - countAnd(&stable, &pos.Stones[1-me]))
+    }
+    if w.Corners != 0 {
+        v += w.Corners * float64(countAnd(&geo.Corners, &pos.Stones[me]) - countAnd(&geo.Corners, &pos.Stones[1-me]))
+    }
+    return v
+}
+
+var DefaultEvaluator Evaluator = Positional{DefaultWeights}
+
+func countAnd(a *Bitset, b *Bitset) int {
+    n := 0
+    for i := range a {
+        n += bits.OnesCount64(a[i] & b[i])
+    }
+    return n
+}
+
+func (geo *Geometry) countMoves(pos *Position, me int) int {
+    n := 0
+    empty := pos.Empty(geo)
+    for w := 0; w < geo.Words; w++ {
+        word := empty[w]
+        for word != 0 {
+            id := w*64 + bits.TrailingZeros64(word)
+            word &= word-1
+            if geo.isLegalFor(pos, id, me) {
+                n++
+            }
+        }
+    }
+    return n
+}
+
+// Empty points next to player's pieces
+func (geo *Geometry) Frontier(pos *Position, player int) Bitset {
+    var front Bitset
+    empty := pos.Empty(geo)
+    for w := 0; w < geo.Words; w++ {
+        word := empty[w]
+        for word != 0 {
+            id := w*64 + bits.TrailingZeros64(word)
+            word &= word-1
+            if geo.intersects(&geo.Adjacent[id], &pos.Stones[player]) {
+                front.Set(id)
+            }
+        }
+    }
+    return front
+}
+
+// A piece is stable when on every line through it the line is full,
+// or one side of it up to the end of the line holds only stable
+// pieces of the same player
+// Repeats until nothing changes, pieces at the ends of lines start it off
+func (geo *Geometry) Stable(pos *Position) Bitset {
+    var stable Bitset
+    var full Bitset
+    full.Or(&pos.Stones[0])
+    full.Or(&pos.Stones[1])
+    sideStable := func(ids []int, own *Bitset) bool {
+        for _,id := range ids {
+            if !own.Has(id) || !stable.Has(id) {
+                return false
+            }
+        }
+        return true
+    }
+    for changed := true; changed; {
+        changed = false
+        for player := 0; player < 2; player++ {
+            own := &pos.Stones[player]
+            for w := 0; w < geo.Words; w++ {
+                word := own[w] &^ stable[w]
+                for word != 0 {
+                    id := w*64 + bits.TrailingZeros64(word)
+                    word &= word-1
+                    ok := true
+                    for _,lp := range geo.index[id] {
+                        if geo.subset(&geo.LineMasks[lp.Line], &full) {
+                            continue
+                        }
+                        ids := geo.lines[lp.Line].Ids
+                        if !sideStable(ids[:lp.Pos], own) && !sideStable(ids[lp.Pos+1:], own) {
+                            ok = false
+                            break
+                        }
+                    }
+                    if ok {
+                        stable.Set(id)
+                        changed = true
+                    }
+                }
+            }
+        }
+    }
+    return stable
+}
+
+func (geo *Geometry) subset(a *Bitset, b *Bitset) bool {
+    for i := 0; i < geo.Words; i++ {
+        if a[i] &^ b[i] != 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Corner-like points are inside the fewest lines
+// On most boards some points are inside none, pieces there never flip
+// Ties among the rest go to points with the fewest neighbors
+func makeCorners(n int, lines []Line, index LineIndex, adjacent []Bitset) Bitset {
+    var corners Bitset
+    inside := make([]int, n)
+    for i := 0; i < n; i++ {
+        for _,lp := range index[i] {
+            if lp.Pos > 0 && lp.Pos < len(lines[lp.Line].Ids)-1 {
+                inside[i]++
+            }
+        }
+    }
+    best := -1
+    bestDeg := 0
+    for i := 0; i < n; i++ {
+        if len(index[i]) == 0 {
+            continue
+        }
+        deg := adjacent[i].Count()
+        if best == -1 || inside[i] < best || (inside[i] == best && deg < bestDeg) {
+            best = inside[i]
+            bestDeg = deg
+        }
+    }
+    for i := 0; i < n; i++ {
+        if len(index[i]) > 0 && inside[i] == best && (best == 0 || adjacent[i].Count() == bestDeg) {
+            corners.Set(i)
+        }
+    }
+    return corners
+}
@@ -0,0 +1,68 @@
+package ai
+
+import (
+    "testing"
+)
+
+func TestCorners(t *testing.T) {
+    geo := MakeTraditional(8).Geometry()
+    expect := []int{0, 7, 56, 63}
+    if !Equals(geo.Corners.Ids(), expect) {
+        t.Errorf("got %v, expect %v", geo.Corners.Ids(), expect)
+    }
+}
+
+func TestStable(t *testing.T) {
+    board := MakeTraditional(4)
+    // Corner, the edge next to it, and an interior point
+    board.Premove(0, 0)
+    board.Premove(1, 0)
+    board.Premove(5, 0)
+    board.Premove(3, 1)
+    board.Premove(10, 1)
+    pos := board.Position()
+    stable := board.Geometry().Stable(&pos)
+    expect := []int{0, 1, 3}
+    if !Equals(stable.Ids(), expect) {
+        t.Errorf("got %v, expect %v", stable.Ids(), expect)
+    }
+    // Every line through a full board is full
+    for i := range board.Points {
+        board.Premove(i, i%2)
+    }
+    pos = board.Position()
+    stable = board.Geometry().Stable(&pos)
+    if stable.Count() != 16 {
+        t.Errorf("got %v, expect %v", stable.Count(), 16)
+    }
+}
+
+func TestFrontier(t *testing.T) {
+    board := benchBoard(t, "")
+    pos := board.Position()
+    front := board.Geometry().Frontier(&pos, 1)
+    // Empty points around the white pieces at 27 and 36
+    expect := []int{18, 19, 20, 26, 29, 34, 37, 43, 44, 45}
+    if !Equals(front.Ids(), expect) {
+        t.Errorf("got %v, expect %v", front.Ids(), expect)
+    }
+}
+
+func TestPositionalEval(t *testing.T) {
+    board := benchBoard(t, "").Clone()
+    geo := board.Geometry()
+    pos := board.Position()
+    discs := Positional{Weights{Discs: 1}}
+    if discs.Eval(geo, &pos, 0) != (DiscCount{}).Eval(geo, &pos, 0) {
+        t.Errorf("disc weight does not match DiscCount")
+    }
+    board.MakeMove(board.GetPossibleMoves()[0])
+    pos = board.Position()
+    mobility := Positional{Weights{Mobility: 1}}
+    me := len(board.GetPossibleMoves())
+    board.Turn++
+    opp := len(board.GetPossibleMoves())
+    if got := mobility.Eval(geo, &pos, 1); got != float64(me - opp) {
+        t.Errorf("got %v, expect %v", got, me - opp)
+    }
+}
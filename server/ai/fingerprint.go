@@ -0,0 +1,127 @@
+package ai
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Fingerprints name the shape of a board: which points are neighbors and
+// which are on which lines in which order. Coordinates, point ids, line
+// order and stones don't count, so boards built from the same plan on
+// different clients, or renumbered, get the same fingerprint. Boards with
+// the same lines and different neighbors differ, clients check moves
+// against the neighbors they draw.
+//
+// Points start out alike and are told apart by their neighbors and the
+// lines through them, then by the points next to them and on those lines,
+// until that splits them no further (color refinement). Two boards that play the same always get the same
+// fingerprint. Boards that differ can in principle share one, as color
+// refinement can't tell every pair of graphs apart, but not by accident
+// of numbering or float jitter.
+
+// Length of a fingerprint in hex digits
+const FingerprintLen = 32
+
+// Colors of the points of a line read from the end that gives the smaller
+// string, and 1 if that was backwards, -1 forwards, 0 if both are the same
+func lineColors(ids []int, colors []int) (string, int) {
+    fwd := make([]string, len(ids))
+    bwd := make([]string, len(ids))
+    for i,id := range ids {
+        fwd[i] = fmt.Sprint(colors[id])
+        bwd[len(ids)-1-i] = fwd[i]
+    }
+    f, b := strings.Join(fwd, ","), strings.Join(bwd, ",")
+    switch {
+    case b < f:
+        return b, 1
+    case b == f:
+        return f, 0
+    }
+    return f, -1
+}
+
+// Replaces each point's signature by its rank among the distinct ones,
+// returns the number of colors
+func recolor(sigs []string, colors []int) int {
+    distinct := append([]string{}, sigs...)
+    sort.Strings(distinct)
+    rank := make(map[string]int)
+    for _,s := range distinct {
+        if _, ok := rank[s]; !ok {
+            rank[s] = len(rank)
+        }
+    }
+    for i,s := range sigs {
+        colors[i] = rank[s]
+    }
+    return len(rank)
+}
+
+// Hex fingerprint of n points joined by neighbors and lines, neighbors
+// may be nil
+//
+// Each round is O(S log S) for S the total length of the lines and the
+// neighbor lists, and there are at most n rounds, usually a handful.
+func Fingerprint(n int, neighbors [][]int, lines []Line) string {
+    h := sha256.New()
+    edges := 0
+    for _,ns := range neighbors {
+        edges += len(ns)
+    }
+    fmt.Fprintf(h, "%d points %d edges %d lines\n", n, edges, len(lines))
+    colors := make([]int, n)
+    index := MakeLineIndex(n, lines)
+    classes := 1
+    sigs := make([]string, n)
+    for round := 0; round <= n; round++ {
+        lsigs := make([]string, len(lines))
+        flipped := make([]int, len(lines))
+        for l,line := range lines {
+            lsigs[l], flipped[l] = lineColors(line.Ids, colors)
+        }
+        for p := 0; p < n; p++ {
+            on := make([]string, len(index[p]))
+            for k,lp := range index[p] {
+                pos, back := lp.Pos, len(lines[lp.Line].Ids)-1-lp.Pos
+                if flipped[lp.Line] > 0 || (flipped[lp.Line] == 0 && back < pos) {
+                    pos = back
+                }
+                on[k] = fmt.Sprintf("%d@%s", pos, lsigs[lp.Line])
+            }
+            sort.Strings(on)
+            near := make([]string, 0)
+            if neighbors != nil {
+                for _,q := range neighbors[p] {
+                    near = append(near, fmt.Sprint(colors[q]))
+                }
+            }
+            sort.Strings(near)
+            sigs[p] = fmt.Sprintf("%d|%s|%s", colors[p], strings.Join(near, ","), strings.Join(on, ";"))
+        }
+        // What the colors stand for goes into the hash too
+        sorted := append([]string{}, sigs...)
+        sort.Strings(sorted)
+        fmt.Fprintln(h, strings.Join(sorted, "\n"))
+        next := recolor(sigs, colors)
+        if next == classes && round > 0 {
+            break
+        }
+        classes = next
+    }
+    lsigs := make([]string, len(lines))
+    for l,line := range lines {
+        lsigs[l], _ = lineColors(line.Ids, colors)
+    }
+    sort.Strings(lsigs)
+    fmt.Fprintln(h, strings.Join(lsigs, "\n"))
+    return hex.EncodeToString(h.Sum(nil)[:FingerprintLen/2])
+}
+
+// Fingerprint of the points, neighbors and lines, stones don't count
+func (board *Board) Fingerprint() string {
+    return Fingerprint(len(board.Points), board.Neighbors, board.Lines)
+}
@@ -0,0 +1,107 @@
+package ai
+
+import (
+    "math/rand"
+    "testing"
+)
+
+// Same board with ids shuffled, lines reversed and reordered, neighbors
+// reordered, jittered points and a few stones
+func renumber(board *Board, seed int64) *Board {
+    rng := rand.New(rand.NewSource(seed))
+    perm := rng.Perm(len(board.Points))
+    points := make([]Point, len(board.Points))
+    for i,p := range board.Points {
+        points[perm[i]] = Point{p.X + rng.Float64()*1e-6, p.Y - rng.Float64()*1e-6, perm[i], -1}
+    }
+    lines := make([]Line, len(board.Lines))
+    for l,line := range board.Lines {
+        ids := make([]int, len(line.Ids))
+        for k,id := range line.Ids {
+            ids[k] = perm[id]
+        }
+        if rng.Intn(2) == 0 {
+            Reverse(ids)
+        }
+        lines[l] = Line{0, ids}
+    }
+    rng.Shuffle(len(lines), func(i, j int) {
+        lines[i], lines[j] = lines[j], lines[i]
+    })
+    out := NewBoard(points, lines)
+    if board.Neighbors != nil {
+        out.Neighbors = make([][]int, len(board.Neighbors))
+        for i,ns := range board.Neighbors {
+            near := make([]int, len(ns))
+            for k,j := range ns {
+                near[k] = perm[j]
+            }
+            rng.Shuffle(len(near), func(a, b int) {
+                near[a], near[b] = near[b], near[a]
+            })
+            out.Neighbors[perm[i]] = near
+        }
+    }
+    out.Premove(perm[0], 0)
+    return out
+}
+
+func TestFingerprint(t *testing.T) {
+    prints := make(map[string]string)
+    for _,name := range []string{"", "David's Original Board", "tripools with hexcenters", "MomBoard"} {
+        board := benchBoard(t, name)
+        fp := board.Fingerprint()
+        if len(fp) != FingerprintLen {
+            t.Fatalf("%q: fingerprint %v", name, fp)
+        }
+        for seed := int64(1); seed <= 3; seed++ {
+            if got := renumber(board, seed).Fingerprint(); got != fp {
+                t.Errorf("%q: renumbered got %v, expect %v", name, got, fp)
+            }
+        }
+        if other, ok := prints[fp]; ok {
+            t.Errorf("%q and %q share a fingerprint", name, other)
+        }
+        prints[fp] = name
+    }
+    // One line more or less is another board
+    board := benchBoard(t, "MomBoard")
+    if Fingerprint(len(board.Points), board.Neighbors, board.Lines[1:]) == board.Fingerprint() {
+        t.Error("missing line not noticed")
+    }
+}
+
+// A line of three points and a point on no line, next to the end of the
+// line or to its middle
+func TestFingerprintNeighbors(t *testing.T) {
+    lines := []Line{{0, []int{0, 1, 2}}}
+    path := [][]int{{1}, {0, 2}, {1}, {}}
+    end := [][]int{{1, 3}, {0, 2}, {1}, {0}}
+    middle := [][]int{{1}, {0, 2, 3}, {1}, {1}}
+    prints := map[string]bool{}
+    for _,ns := range [][][]int{nil, path, end, middle} {
+        prints[Fingerprint(4, ns, lines)] = true
+    }
+    if len(prints) != 4 {
+        t.Errorf("%d fingerprints for 4 boards with the same lines", len(prints))
+    }
+    // The same as end, numbered the other way
+    if Fingerprint(4, [][]int{{1}, {0, 2}, {1, 3}, {2}}, []Line{{0, []int{2, 1, 0}}}) != Fingerprint(4, end, lines) {
+        t.Error("renumbered board differs")
+    }
+}
+
+// These plans differ in steps that place nothing
+func TestFingerprintDuplicateBoards(t *testing.T) {
+    a, _, err := MakeFromPlan(loadTestPlan(t, "big egg verticie pods"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    b, _, err := MakeFromPlan(loadTestPlan(t, "needs rhombus or triangular snub"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a.Fingerprint() != b.Fingerprint() {
+        t.Error("same boards, different fingerprints")
+    }
+}
@@ -0,0 +1,212 @@
+package ai
+
+import (
+    "errors"
+    "math"
+    "math/rand"
+    "testing"
+    "time"
+)
+
+// Random planar points and neighbor graphs
+// Shape 0 is a jittered grid with one diagonal per cell, shape 1 is rings
+// joined by spokes, which lets lines curve all the way around
+func fuzzGeometry(seed int64, size int, shape int) ([]Point, [][]int) {
+    rng := rand.New(rand.NewSource(seed))
+    points := make([]Point, 0)
+    ns := make([][]int, 0)
+    add := func(x float64, y float64) {
+        points = append(points, Point{X: x, Y: y, Id: len(points), Player: -1})
+        ns = append(ns, make([]int, 0))
+    }
+    link := func(a int, b int) {
+        if a != b && !Includes(ns[a], b) {
+            ns[a] = append(ns[a], b)
+            ns[b] = append(ns[b], a)
+        }
+    }
+    if shape % 2 == 0 {
+        rows, cols := 2 + size % 7, 2 + size / 7 % 7
+        for r := 0; r < rows; r++ {
+            for c := 0; c < cols; c++ {
+                add(float64(c) + (rng.Float64()-0.5) * 0.4, float64(r) + (rng.Float64()-0.5) * 0.4)
+            }
+        }
+        for r := 0; r < rows; r++ {
+            for c := 0; c < cols; c++ {
+                id := r*cols + c
+                // Missing edges make holes
+                if c+1 < cols && rng.Intn(8) != 0 {
+                    link(id, id+1)
+                }
+                if r+1 < rows && rng.Intn(8) != 0 {
+                    link(id, id+cols)
+                }
+                if r+1 < rows && c+1 < cols {
+                    switch rng.Intn(3) {
+                    case 0:
+                        link(id, id+cols+1)
+                    case 1:
+                        link(id+1, id+cols)
+                    }
+                }
+            }
+        }
+    } else {
+        rings, spokes := 1 + size % 4, 3 + size / 4 % 14
+        for i := 0; i < rings; i++ {
+            for j := 0; j < spokes; j++ {
+                t := 2 * math.Pi * (float64(j) + rng.Float64() * 0.2) / float64(spokes)
+                r := float64(i+1) + rng.Float64() * 0.2
+                add(r * math.Cos(t), r * math.Sin(t))
+                id := i*spokes + j
+                if j > 0 {
+                    link(id, id-1)
+                }
+                if i > 0 {
+                    link(id, id-spokes)
+                }
+            }
+            link(i*spokes, i*spokes + spokes-1)
+        }
+    }
+    return points, ns
+}
+
+// Lines with a time limit, none if there are too many
+func fuzzLines(t *testing.T, points []Point, ns [][]int) []Line {
+    done := make(chan []Line, 1)
+    go func() {
+        lines, err := ExtractLines(points, ns, DefaultLineOptions)
+        if err != nil && !errors.Is(err, ErrTooManyLines) {
+            t.Error(err)
+        }
+        done <- lines
+    }()
+    select {
+    case lines := <-done:
+        return lines
+    case <-time.After(5 * time.Second):
+        t.Fatalf("line generation of %d points didn't finish", len(points))
+    }
+    return nil
+}
+
+func FuzzMakeLines(f *testing.F) {
+    f.Add(int64(1), uint8(20), uint8(0))
+    f.Add(int64(2), uint8(48), uint8(0))
+    f.Add(int64(3), uint8(7), uint8(1))
+    f.Add(int64(4), uint8(30), uint8(1))
+    f.Add(int64(5), uint8(55), uint8(1))
+    f.Fuzz(func(t *testing.T, seed int64, size uint8, shape uint8) {
+        points, ns := fuzzGeometry(seed, int(size), int(shape))
+        lines := fuzzLines(t, points, ns)
+        for _,line := range lines {
+            if len(line.Ids) < 3 {
+                t.Fatalf("short line %v", line.Ids)
+            }
+            for i,id := range line.Ids {
+                if id < 0 || id >= len(points) {
+                    t.Fatalf("line %v has point %d out of range", line.Ids, id)
+                }
+                if Includes(line.Ids[:i], id) {
+                    t.Fatalf("line %v has point %d twice", line.Ids, id)
+                }
+                if i > 0 && !Includes(ns[line.Ids[i-1]], id) {
+                    t.Fatalf("line %v steps from %d to %d, not neighbors", line.Ids, line.Ids[i-1], id)
+                }
+            }
+        }
+    })
+}
+
+// Stones a move at to captures, found by scanning every line
+func fuzzCaptures(board *Board, to int) map[int]bool {
+    me := board.Turn % 2
+    captured := make(map[int]bool)
+    for _,line := range board.Lines {
+        for pos,id := range line.Ids {
+            if id != to {
+                continue
+            }
+            for _,dir := range []int{-1, 1} {
+                run := make([]int, 0)
+                i := pos + dir
+                for ; i >= 0 && i < len(line.Ids) && board.Points[line.Ids[i]].Player == 1-me; i += dir {
+                    run = append(run, line.Ids[i])
+                }
+                if len(run) > 0 && i >= 0 && i < len(line.Ids) && board.Points[line.Ids[i]].Player == me {
+                    for _,c := range run {
+                        captured[c] = true
+                    }
+                }
+            }
+        }
+    }
+    return captured
+}
+
+func FuzzMakeMove(f *testing.F) {
+    f.Add(int64(1), uint8(48), uint8(0))
+    f.Add(int64(2), uint8(35), uint8(0))
+    f.Add(int64(3), uint8(30), uint8(1))
+    f.Add(int64(4), uint8(53), uint8(1))
+    f.Fuzz(func(t *testing.T, seed int64, size uint8, shape uint8) {
+        points, ns := fuzzGeometry(seed, int(size), int(shape))
+        lines := fuzzLines(t, points, ns)
+        board := NewBoard(points, lines)
+        if len(points) > MaxPoints {
+            return
+        }
+        rng := rand.New(rand.NewSource(seed))
+        for i := 0; i < 4; i++ {
+            board.Premove(rng.Intn(len(points)), i % 2)
+        }
+        geo := board.Geometry()
+        pos := board.Position()
+        for !board.GameOver() {
+            moves := board.GetPossibleMoves()
+            for id := range board.Points {
+                if board.MoveIsLegal(id) != Includes(moves, id) {
+                    t.Fatalf("legality of %d differs from the move list", id)
+                }
+            }
+            if !Equals(geo.Moves(&pos, nil), moves) {
+                t.Fatalf("bitset moves %v, board moves %v", geo.Moves(&pos, nil), moves)
+            }
+            move := Pass
+            if len(moves) > 0 {
+                move = moves[rng.Intn(len(moves))]
+            }
+            want := make(map[int]bool)
+            if move != Pass {
+                want = fuzzCaptures(board, move)
+                if len(want) == 0 {
+                    t.Fatalf("legal move %d captures nothing", move)
+                }
+            }
+            prev := board.Clone()
+            me := board.Turn % 2
+            board.MakeMove(move)
+            geo.MakeMove(&pos, move)
+            for id,p := range board.Points {
+                was := prev.Points[id].Player
+                switch {
+                case id == move:
+                    if was != -1 || p.Player != me {
+                        t.Fatalf("move %d not placed", move)
+                    }
+                case want[id]:
+                    if p.Player != me {
+                        t.Fatalf("move %d didn't flip %d", move, id)
+                    }
+                case p.Player != was:
+                    t.Fatalf("move %d flipped %d, which it can't capture", move, id)
+                }
+            }
+            if board.Position() != pos {
+                t.Fatal("bitset position differs from the board")
+            }
+        }
+    })
+}
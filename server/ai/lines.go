@@ -0,0 +1,285 @@
+package ai
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// How a board decides where lines go, board files declare it
+type LineModel string
+
+const (
+    // Lines keep going while they turn less than LineOptions.MaxTurn
+    AngleLines LineModel = "angle"
+    // Lines cross each point opposite where they came in, see PolygonLines
+    PolygonLines LineModel = "polygon"
+)
+
+// How ExtractLines decides what counts as a straight line
+// PolygonLines only use MaxLength
+type LineOptions struct {
+    // Largest turn in radians between two steps of a line
+    MaxTurn float64
+    // Most points in a line, 0 for no limit other than the board
+    MaxLength int
+    // Follow every continuation within MaxTurn, not just the straightest
+    Bifurcate bool
+    // Most lines a board may have, 0 for no limit
+    MaxLines int
+}
+
+// The lines of the old ContinueLines and CombineLines pipeline, except that
+// a line round a loop may go round it the other way, see
+// TestExtractLinesMatchOld. Boards that would have more than MaxLines lines,
+// like the growing spirals, are refused rather than cut into shorter lines.
+var DefaultLineOptions = LineOptions{
+    MaxTurn: math.Pi/4 - 0.01,
+    MaxLength: 18,
+    Bifurcate: true,
+    MaxLines: 25000,
+}
+
+// Returned when a board has more than LineOptions.MaxLines lines
+var ErrTooManyLines = errors.New("Too many lines on the board")
+
+func (opts LineOptions) tooMany(n int) error {
+    if opts.MaxLines > 0 && n > opts.MaxLines {
+        return fmt.Errorf("%w: more than %d", ErrTooManyLines, opts.MaxLines)
+    }
+    return nil
+}
+
+// Turn from direction a->b to direction b->c, in [-pi, pi]
+func turn(points []Point, a int, b int, c int) float64 {
+    t1 := math.Atan2(points[b].Y - points[a].Y, points[b].X - points[a].X)
+    t2 := math.Atan2(points[c].Y - points[b].Y, points[c].X - points[b].X)
+    td := t2 - t1
+    for td > math.Pi {
+        td -= 2*math.Pi
+    }
+    for td < -math.Pi {
+        td += 2*math.Pi
+    }
+    return td
+}
+
+// Neighbors of b that continue a->b, straightest first, ties by id
+func (opts LineOptions) next(points []Point, neighbors [][]int, a int, b int, used map[int]bool) []int {
+    cand := make([]int, 0)
+    turns := make(map[int]float64)
+    for _,c := range neighbors[b] {
+        if used[c] {
+            continue
+        }
+        td := math.Abs(turn(points, a, b, c))
+        if td < opts.MaxTurn {
+            cand = append(cand, c)
+            turns[c] = td
+        }
+    }
+    sort.Slice(cand, func(i, j int) bool {
+        if turns[cand[i]] != turns[cand[j]] {
+            return turns[cand[i]] < turns[cand[j]]
+        }
+        return cand[i] < cand[j]
+    })
+    if !opts.Bifurcate && len(cand) > 1 {
+        cand = cand[:1]
+    }
+    return cand
+}
+
+// Every way to continue a->b by at most budget points, avoiding used
+// A negative budget has no limit, each ray lists the points after b
+// More than opts.MaxLines rays make too many lines, as each makes another
+func (opts LineOptions) rays(points []Point, neighbors [][]int, a int, b int, budget int, used map[int]bool) ([][]int, error) {
+    rays := make([][]int, 0)
+    stack := [][]int{{}}
+    for len(stack) > 0 {
+        ray := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        prev, last := a, b
+        if n := len(ray); n > 0 {
+            last = ray[n-1]
+            prev = b
+            if n > 1 {
+                prev = ray[n-2]
+            }
+        }
+        var cand []int
+        if budget < 0 || len(ray) < budget {
+            for _,p := range ray {
+                used[p] = true
+            }
+            cand = opts.next(points, neighbors, prev, last, used)
+            for _,p := range ray {
+                delete(used, p)
+            }
+        }
+        if len(cand) == 0 {
+            rays = append(rays, ray)
+            if err := opts.tooMany(len(rays)); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        // Pushed in reverse so the straightest ray comes out first
+        for i := len(cand)-1; i >= 0; i-- {
+            next := make([]int, len(ray), len(ray)+1)
+            copy(next, ray)
+            stack = append(stack, append(next, cand[i]))
+        }
+    }
+    return rays, nil
+}
+
+// Lines through the neighbor graph of points
+//
+// Every edge i-j is grown backwards past i and then forwards past j, one
+// point at a time, keeping points whose turn is under opts.MaxTurn and that
+// are not already on the line, until the line has opts.MaxLength points.
+// Edges are grown from both ends, so a line longer than that leaves a window
+// ending at each of its points.
+// Lines of two points are dropped, as are lines whose points all lie on a
+// longer line. Lines run from the lower end id to the higher and are sorted
+// by their ids, so the output only depends on the points and neighbors, not
+// on map order or the order of neighbors.
+//
+// With E edges, lines of at most L points and at most b continuations
+// within MaxTurn at any point, growing takes O(E * L) steps without
+// Bifurcate and O(E * b^L) with it. On boards made of polygons b is rarely
+// more than 2, but boards with many near straight turns reach tens of
+// thousands of lines at L = 18. L is opts.MaxLength, or the number of
+// points when MaxLength is 0. Culling takes O(N * k * L) for N lines
+// through at most k lines per point.
+//
+// Growing stops with ErrTooManyLines once more than opts.MaxLines different
+// lines have been grown, counted before culling, so with MaxLines set the
+// work is at most O(MaxLines * L^2) whatever the board.
+func ExtractLines(points []Point, neighbors [][]int, opts LineOptions) ([]Line, error) {
+    found := make([][]int, 0)
+    seen := make(map[string]bool)
+    for i := range points {
+        for _,j := range neighbors[i] {
+            used := map[int]bool{i: true, j: true}
+            budget := -1
+            if opts.MaxLength > 0 {
+                budget = opts.MaxLength - 2
+            }
+            backs, err := opts.rays(points, neighbors, j, i, budget, used)
+            if err != nil {
+                return nil, err
+            }
+            for _,back := range backs {
+                for _,p := range back {
+                    used[p] = true
+                }
+                fbudget := budget
+                if budget >= 0 {
+                    fbudget = budget - len(back)
+                }
+                fwds, err := opts.rays(points, neighbors, i, j, fbudget, used)
+                if err != nil {
+                    return nil, err
+                }
+                for _,fwd := range fwds {
+                    ids := make([]int, 0, len(back)+len(fwd)+2)
+                    for k := len(back)-1; k >= 0; k-- {
+                        ids = append(ids, back[k])
+                    }
+                    ids = append(ids, i, j)
+                    ids = append(ids, fwd...)
+                    found = append(found, ids)
+                    if len(ids) > 2 {
+                        seen[lineKey(oriented(ids))] = true
+                    }
+                }
+                if err := opts.tooMany(len(seen)); err != nil {
+                    return nil, err
+                }
+                for _,p := range back {
+                    delete(used, p)
+                }
+            }
+        }
+    }
+    return finishLines(found, len(points)), nil
+}
+
+// Ids from the lower end id to the higher, reversed in place
+func oriented(ids []int) []int {
+    if ids[0] > ids[len(ids)-1] {
+        Reverse(ids)
+    }
+    return ids
+}
+
+// Drops short and repeated lines, orients them from the lower end id and
+// sorts them
+func finishLines(found [][]int, n int) []Line {
+    seen := make(map[string]bool)
+    lines := make([]Line, 0)
+    for _,ids := range found {
+        if len(ids) < 3 {
+            continue
+        }
+        key := lineKey(oriented(ids))
+        if !seen[key] {
+            seen[key] = true
+            lines = append(lines, Line{0, ids})
+        }
+    }
+    lines = cullContained(lines, n)
+    sort.Slice(lines, func(a, b int) bool {
+        return lessIds(lines[a].Ids, lines[b].Ids)
+    })
+    return lines
+}
+
+func lineKey(ids []int) string {
+    b := make([]byte, 0, 2*len(ids))
+    for _,id := range ids {
+        b = append(b, byte(id), byte(id >> 8))
+    }
+    return string(b)
+}
+
+func lessIds(a []int, b []int) bool {
+    for k := 0; k < len(a) && k < len(b); k++ {
+        if a[k] != b[k] {
+            return a[k] < b[k]
+        }
+    }
+    return len(a) < len(b)
+}
+
+// Drops lines whose points all lie on a longer line, or on a line with the
+// same points and lower ids
+func cullContained(lines []Line, n int) []Line {
+    through := make([][]int, n)
+    for l,line := range lines {
+        for _,id := range line.Ids {
+            through[id] = append(through[id], l)
+        }
+    }
+    keep := make([]Line, 0, len(lines))
+    for l,line := range lines {
+        contained := false
+        for _,o := range through[line.Ids[0]] {
+            other := lines[o].Ids
+            if o == l || len(other) < len(line.Ids) || (len(other) == len(line.Ids) && !lessIds(other, line.Ids)) {
+                continue
+            }
+            if IsSubset(line.Ids, other) {
+                contained = true
+                break
+            }
+        }
+        if !contained {
+            keep = append(keep, line)
+        }
+    }
+    return keep
+}
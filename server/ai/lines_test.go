@@ -0,0 +1,208 @@
+package ai
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "math/rand"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+// A row of n points along the x axis
+func rowPoints(n int) ([]Point, [][]int) {
+    points := make([]Point, n)
+    ns := make([][]int, n)
+    for i := range points {
+        points[i] = Point{float64(i), 0, i, -1}
+        if i > 0 {
+            ns[i] = append(ns[i], i-1)
+            ns[i-1] = append(ns[i-1], i)
+        }
+    }
+    return points, ns
+}
+
+func extractLines(t *testing.T, points []Point, ns [][]int, opts LineOptions) []Line {
+    t.Helper()
+    lines, err := ExtractLines(points, ns, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return lines
+}
+
+func TestExtractLinesLength(t *testing.T) {
+    points, ns := rowPoints(6)
+    lines := extractLines(t, points, ns, DefaultLineOptions)
+    if len(lines) != 1 || !reflect.DeepEqual(lines[0].Ids, []int{0,1,2,3,4,5}) {
+        t.Fatalf("got %v", lines)
+    }
+    opts := DefaultLineOptions
+    opts.MaxLength = 4
+    lines = extractLines(t, points, ns, opts)
+    expect := [][]int{{0,1,2,3}, {1,2,3,4}, {2,3,4,5}}
+    if len(lines) != len(expect) {
+        t.Fatalf("got %v", lines)
+    }
+    for i,line := range lines {
+        if !reflect.DeepEqual(line.Ids, expect[i]) {
+            t.Errorf("got %v, expect %v", line.Ids, expect[i])
+        }
+    }
+}
+
+// Windows don't depend on which way the ids run
+func TestExtractLinesWindows(t *testing.T) {
+    perm := []int{0, 1, 5, 4, 2, 3}
+    points := make([]Point, len(perm))
+    ns := make([][]int, len(perm))
+    for k,id := range perm {
+        points[id] = Point{float64(k), 0, id, -1}
+        if k > 0 {
+            ns[id] = append(ns[id], perm[k-1])
+            ns[perm[k-1]] = append(ns[perm[k-1]], id)
+        }
+    }
+    opts := DefaultLineOptions
+    opts.MaxLength = 4
+    lines := extractLines(t, points, ns, opts)
+    expect := [][]int{{0,1,5,4}, {1,5,4,2}, {3,2,4,5}}
+    if len(lines) != len(expect) {
+        t.Fatalf("got %v", lines)
+    }
+    for i,line := range lines {
+        if !reflect.DeepEqual(line.Ids, expect[i]) {
+            t.Errorf("got %v, expect %v", line.Ids, expect[i])
+        }
+    }
+}
+
+// Forks at both ends of 2-3, the straight way is 0-2-3-4
+func TestExtractLinesBifurcate(t *testing.T) {
+    points := []Point{
+        Point{0,0,0,-1},
+        Point{0,0.5,1,-1},
+        Point{1,0,2,-1},
+        Point{2,0,3,-1},
+        Point{3,0,4,-1},
+        Point{3,0.5,5,-1},
+    }
+    ns := [][]int{{2}, {2}, {0,1,3}, {2,4,5}, {3}, {3}}
+    lines := extractLines(t, points, ns, DefaultLineOptions)
+    expect := [][]int{{0,2,3,4}, {0,2,3,5}, {1,2,3,4}, {1,2,3,5}}
+    if len(lines) != len(expect) {
+        t.Fatalf("bifurcating got %v", lines)
+    }
+    for i,line := range lines {
+        if !reflect.DeepEqual(line.Ids, expect[i]) {
+            t.Errorf("got %v, expect %v", line.Ids, expect[i])
+        }
+    }
+    // Each edge only follows the straightest way, nothing goes 1 to 5
+    opts := DefaultLineOptions
+    opts.Bifurcate = false
+    lines = extractLines(t, points, ns, opts)
+    if len(lines) != 3 || !reflect.DeepEqual(lines[2].Ids, []int{1,2,3,4}) {
+        t.Fatalf("straightest got %v", lines)
+    }
+    opts.MaxTurn = math.Pi/8
+    if lines = extractLines(t, points, ns, opts); len(lines) != 1 {
+        t.Fatalf("pi/8 got %v", lines)
+    }
+}
+
+// Lines don't depend on the order of neighbors
+func TestExtractLinesDeterministic(t *testing.T) {
+    points, ns, err := PlanToPoints(loadTestPlan(t, "tripools with hexcenters"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := extractLines(t, points, ns, DefaultLineOptions)
+    rng := rand.New(rand.NewSource(1))
+    for _,n := range ns {
+        rng.Shuffle(len(n), func(i, j int) {
+            n[i], n[j] = n[j], n[i]
+        })
+    }
+    if got := extractLines(t, points, ns, DefaultLineOptions); !reflect.DeepEqual(got, expect) {
+        t.Fatal("lines changed with the order of neighbors")
+    }
+}
+
+func TestExtractLinesMaxLines(t *testing.T) {
+    points, ns := rowPoints(6)
+    opts := DefaultLineOptions
+    opts.MaxLength = 4
+    opts.MaxLines = 3
+    if lines := extractLines(t, points, ns, opts); len(lines) != 3 {
+        t.Fatalf("got %v", lines)
+    }
+    opts.MaxLines = 2
+    if lines, err := ExtractLines(points, ns, opts); !errors.Is(err, ErrTooManyLines) || lines != nil {
+        t.Fatalf("got %v %v", lines, err)
+    }
+    // Branching lines of 18 points on the spiral run into the thousands
+    points, ns, err := PlanToPoints(loadTestPlan(t, "growing spiral 2"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ExtractLines(points, ns, DefaultLineOptions); !errors.Is(err, ErrTooManyLines) {
+        t.Fatal(err)
+    }
+}
+
+// The pipeline MakeLines used before ExtractLines
+func oldLines(points []Point, ns [][]int) []Line {
+    lines := PointsToLinesGood(points, ns)
+    lines = CullShortLines(lines)
+    for i := 0; i < 2; i++ {
+        lines = CullEqualLines(lines)
+        lines = CullSubsetLines(lines)
+        lines = CombineLines(lines)
+    }
+    lines = CullEqualLines(lines)
+    lines = CullSubsetLines(lines)
+    return lines
+}
+
+// Lines as strings, oriented from the lower end id, or as sorted point sets
+func lineKeys(lines []Line, sorted bool) map[string]bool {
+    keys := make(map[string]bool)
+    for _,line := range lines {
+        ids := append([]int{}, line.Ids...)
+        if sorted {
+            sort.Ints(ids)
+        }
+        keys[fmt.Sprint(oriented(ids))] = true
+    }
+    return keys
+}
+
+// DefaultLineOptions keep the lines of the old pipeline. Where lines branch,
+// a line through a loop may go round it either way and the two pipelines
+// don't always pick the same one, so those boards only keep the points of
+// each line.
+func TestExtractLinesMatchOld(t *testing.T) {
+    boards := map[string]bool{
+        "testboardseethis": false,
+        "NeverPlaceTest": false,
+        "First Dodecagon Board": false,
+        "a failed attempt at 3636 and overlapping hez": false,
+        "Classic Altered": true,
+        "David's Original Board": true,
+        "MomBoard": true,
+    }
+    for name,branching := range boards {
+        points, ns, err := PlanToPoints(loadTestPlan(t, name))
+        if err != nil {
+            t.Fatal(err)
+        }
+        got := lineKeys(extractLines(t, points, ns, DefaultLineOptions), branching)
+        expect := lineKeys(oldLines(points, ns), branching)
+        if !reflect.DeepEqual(got, expect) {
+            t.Errorf("%s: %d lines, expect %d", name, len(got), len(expect))
+        }
+    }
+}
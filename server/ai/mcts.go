@@ -0,0 +1,257 @@
+package ai
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "time"
+)
+
+// Monte Carlo tree search with UCT
+// Nodes keep only the move into them, positions are replayed from the root
+
+// Engines pick the next board, nil if it isn't me's turn or the game is over
+type Engine interface {
+    Search(ctx context.Context, board *Board, me int) *Board
+}
+
+// Engines whose time per move can change between moves, e.g. with a game clock
+type Timed interface {
+    SetTimeMillis(timeMillis int)
+}
+
+type mctsNode struct {
+    parent *mctsNode
+    children []*mctsNode
+    // Moves not yet expanded, nil until the node is first visited
+    untried []int
+    expanded bool
+    move int
+    // Player who made move
+    player int
+    key uint64
+    turn int
+    visits int
+    // Sum of results for player
+    wins float64
+}
+
+// Iterations or TimeMillis of 0 means no limit, 1000 iterations if both are 0
+// Rollout nil plays uniformly random moves, otherwise each rollout move
+// is the best of Sample random moves by Rollout
+// The tree is kept for the next move when the game continues from it
+type MCTS struct {
+    Iterations int
+    TimeMillis int
+    // Exploration constant, sqrt(2) if 0
+    C float64
+    Rollout Evaluator
+    Sample int
+    Seed int64
+    rng *rand.Rand
+    geo *Geometry
+    root *mctsNode
+    moves []int
+}
+
+func (m *MCTS) SetTimeMillis(timeMillis int) {
+    m.TimeMillis = timeMillis
+}
+
+// Same calling convention as Search, depth is the number of iterations
+func SearchMCTS(ctx context.Context, board *Board, me int, iterations int, timeMillis int) *Board {
+    m := &MCTS{Iterations: iterations, TimeMillis: timeMillis}
+    return m.Search(ctx, board, me)
+}
+
+// Stops early when ctx is done, returning the best move so far
+func (m *MCTS) Search(ctx context.Context, board *Board, me int) *Board {
+    if board.Turn % 2 != me || board.GameOver() {
+        return nil
+    }
+    startTime := time.Now()
+    if m.rng == nil {
+        m.rng = rand.New(rand.NewSource(m.Seed))
+    }
+    geo := board.Geometry()
+    pos := board.Position()
+    m.reuse(geo, &pos)
+    iterations := m.Iterations
+    if iterations == 0 && m.TimeMillis == 0 {
+        iterations = 1000
+    }
+    for i := 0; iterations == 0 || i < iterations; i++ {
+        if ctx.Err() != nil {
+            break
+        }
+        if m.TimeMillis > 0 && i % 64 == 0 && time.Since(startTime).Milliseconds() > int64(m.TimeMillis) {
+            break
+        }
+        m.iterate(&pos)
+    }
+    best := m.bestChild()
+    if best == nil {
+        return nil
+    }
+    next := board.Clone()
+    next.MakeMove(best.move)
+    return next
+}
+
+// Root visits, for tests and logging
+func (m *MCTS) Visits() int {
+    if m.root == nil {
+        return 0
+    }
+    return m.root.visits
+}
+
+// Look for the position two plies down, our move and the opponent's
+func (m *MCTS) reuse(geo *Geometry, pos *Position) {
+    key := pos.Hash()
+    if m.root != nil && m.geo == geo {
+        found := findNode(m.root, key, pos.Turn, 2)
+        if found != nil {
+            found.parent = nil
+            m.root = found
+            return
+        }
+    }
+    m.geo = geo
+    m.root = &mctsNode{move: NoMove, player: 1 - pos.Turn % 2, key: key, turn: pos.Turn}
+}
+
+func findNode(node *mctsNode, key uint64, turn int, depth int) *mctsNode {
+    if node.key == key && node.turn == turn {
+        return node
+    }
+    if depth == 0 {
+        return nil
+    }
+    for _,c := range node.children {
+        if found := findNode(c, key, turn, depth-1); found != nil {
+            return found
+        }
+    }
+    return nil
+}
+
+func (m *MCTS) bestChild() *mctsNode {
+    var best *mctsNode
+    for _,c := range m.root.children {
+        if best == nil || c.visits > best.visits {
+            best = c
+        }
+    }
+    return best
+}
+
+// Pass when there are no moves but the opponent has some
+func (m *MCTS) legalMoves(pos *Position) []int {
+    m.moves = m.geo.Moves(pos, m.moves[:0])
+    if len(m.moves) == 0 && m.geo.hasMovesFor(pos, 1 - pos.Turn % 2) {
+        m.moves = append(m.moves, Pass)
+    }
+    return m.moves
+}
+
+func (m *MCTS) expand(node *mctsNode, pos *Position) {
+    moves := m.legalMoves(pos)
+    node.untried = make([]int, len(moves))
+    copy(node.untried, moves)
+    node.expanded = true
+}
+
+func (m *MCTS) uct(node *mctsNode) *mctsNode {
+    c := m.C
+    if c == 0 {
+        c = math.Sqrt2
+    }
+    logN := math.Log(float64(node.visits))
+    var best *mctsNode
+    bestVal := math.Inf(-1)
+    for _,ch := range node.children {
+        val := ch.wins/float64(ch.visits) + c*math.Sqrt(logN/float64(ch.visits))
+        if val > bestVal {
+            best = ch
+            bestVal = val
+        }
+    }
+    return best
+}
+
+// Select, expand, roll out and back up once
+func (m *MCTS) iterate(root *Position) {
+    pos := *root
+    node := m.root
+    for {
+        if !node.expanded {
+            m.expand(node, &pos)
+        }
+        if len(node.untried) > 0 || len(node.children) == 0 {
+            break
+        }
+        node = m.uct(node)
+        m.geo.MakeMove(&pos, node.move)
+    }
+    if len(node.untried) > 0 {
+        i := m.rng.Intn(len(node.untried))
+        move := node.untried[i]
+        node.untried[i] = node.untried[len(node.untried)-1]
+        node.untried = node.untried[:len(node.untried)-1]
+        player := pos.Turn % 2
+        m.geo.MakeMove(&pos, move)
+        child := &mctsNode{parent: node, move: move, player: player, key: pos.Hash(), turn: pos.Turn}
+        node.children = append(node.children, child)
+        node = child
+    }
+    res := m.rollout(&pos)
+    for ; node != nil; node = node.parent {
+        node.visits++
+        node.wins += res[node.player]
+    }
+}
+
+// Results for each player, 1 win, 0.5 draw, 0 loss
+func (m *MCTS) rollout(pos *Position) [2]float64 {
+    for {
+        moves := m.legalMoves(pos)
+        if len(moves) == 0 {
+            break
+        }
+        move := moves[m.rng.Intn(len(moves))]
+        if m.Rollout != nil && len(moves) > 1 {
+            move = m.guided(pos, moves)
+        }
+        m.geo.MakeMove(pos, move)
+    }
+    d := pos.Eval(0)
+    if d > 0 {
+        return [2]float64{1, 0}
+    } else if d < 0 {
+        return [2]float64{0, 1}
+    }
+    return [2]float64{0.5, 0.5}
+}
+
+// Best of a few random moves for the player to move
+func (m *MCTS) guided(pos *Position, moves []int) int {
+    n := m.Sample
+    if n == 0 {
+        n = 3
+    }
+    me := pos.Turn % 2
+    best := NoMove
+    bestVal := math.Inf(-1)
+    for k := 0; k < n; k++ {
+        move := moves[m.rng.Intn(len(moves))]
+        next := *pos
+        m.geo.MakeMove(&next, move)
+        val := m.Rollout.Eval(m.geo, &next, me)
+        if val > bestVal {
+            best = move
+            bestVal = val
+        }
+    }
+    return best
+}
@@ -0,0 +1,69 @@
+package ai
+
+import (
+    "context"
+    "testing"
+)
+
+func TestMCTSSearch(t *testing.T) {
+    board := benchBoard(t, "")
+    for _,rollout := range []Evaluator{nil, DefaultEvaluator} {
+        m := &MCTS{Iterations: 300, Rollout: rollout}
+        next := m.Search(context.Background(), board.Clone(), 0)
+        if next == nil || next.Turn != 1 {
+            t.Fatalf("got %v", next)
+        }
+        move := -1
+        for i := range next.Points {
+            if board.Points[i].Player == -1 && next.Points[i].Player == 0 {
+                move = i
+            }
+        }
+        if !board.MoveIsLegal(move) {
+            t.Errorf("illegal move %v", move)
+        }
+        if m.Visits() != 300 {
+            t.Errorf("got %v, expect %v", m.Visits(), 300)
+        }
+        if m.Search(context.Background(), next, 0) != nil {
+            t.Errorf("expect nil when not my turn")
+        }
+    }
+}
+
+func TestMCTSPass(t *testing.T) {
+    board := passBoard()
+    next := SearchMCTS(context.Background(), board, 1, 50, 0)
+    if next == nil || next.Turn != 2 || !Equals(next.Points, board.Points) {
+        t.Errorf("expect MCTS to pass")
+    }
+}
+
+// The subtree after our move and the reply is kept
+func TestMCTSReuse(t *testing.T) {
+    board := benchBoard(t, "").Clone()
+    m := &MCTS{Iterations: 2000}
+    board = m.Search(context.Background(), board, 0)
+    board.MakeMove(board.GetPossibleMoves()[0])
+    m.Search(context.Background(), board, 0)
+    if m.Visits() <= 2000 {
+        t.Errorf("got %v visits, expect more than %v", m.Visits(), 2000)
+    }
+}
+
+func benchMCTS(b *testing.B, name string, rollout Evaluator) {
+    start := benchBoard(b, name)
+    b.ResetTimer()
+    for n := 0; n < b.N; n++ {
+        m := &MCTS{Iterations: 200, Rollout: rollout}
+        m.Search(context.Background(), start.Clone(), 0)
+    }
+}
+
+func BenchmarkMCTSRandomTraditional8(b *testing.B) {
+    benchMCTS(b, "", nil)
+}
+
+func BenchmarkMCTSGuidedTraditional8(b *testing.B) {
+    benchMCTS(b, "", DefaultEvaluator)
+}
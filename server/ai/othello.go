@@ -14,15 +14,74 @@ type Point struct {
     Player int
 }
 
+// A player with no legal moves must pass
+const Pass = -1
+
 type Line struct {
     M float64
     Ids []int
 }
 
+// Where a point sits in a line
+type LinePos struct {
+    Line int
+    Pos int
+}
+
+// Lines through each point id
+// Built once from Lines and shared by all clones of a board
+type LineIndex [][]LinePos
+
 type Board struct {
     Points []Point
     Lines []Line
+    // Of each point, nil if not known, only Fingerprint uses them
+    Neighbors [][]int
     Turn int
+    index LineIndex
+    geo *Geometry
+    // Zobrist key of the stones, see Hash
+    hash uint64
+    hashed bool
+}
+
+func MakeLineIndex(n int, lines []Line) LineIndex {
+    index := make(LineIndex, n)
+    for j,line := range lines {
+        for i,pId := range line.Ids {
+            index[pId] = append(index[pId], LinePos{j, i})
+        }
+    }
+    return index
+}
+
+// Lines must not change after the board is made
+// Clones share the line index and hash made here
+func NewBoard(points []Point, lines []Line) *Board {
+    board := &Board{
+        Points: points,
+        Lines: lines,
+        Turn: 0,
+        index: MakeLineIndex(len(points), lines),
+    }
+    board.Rehash()
+    if len(points) <= MaxPoints {
+        board.Geometry()
+    }
+    return board
+}
+
+// Boards made without NewBoard build their index on first use
+// Clone doesn't, so cloning never writes to the board being cloned
+func (board *Board) lineIndex() LineIndex {
+    if board.index == nil {
+        board.index = MakeLineIndex(len(board.Points), board.Lines)
+    }
+    return board.index
+}
+
+func (board *Board) PointLines(id int) []LinePos {
+    return board.lineIndex()[id]
 }
 
 func Includes[T comparable](s []T, a T) bool {
@@ -154,6 +213,12 @@ func TryCombine(l1 []int, l2 []int) []int {
                 break
             }
         }
+        // Lines around a ring would meet themselves
+        for j := i; olp && j < n; j++ {
+            if Includes(l1, l2[j]) {
+                olp = false
+            }
+        }
         if olp {
             nl := make([]int, m)
             copy(nl, l1)
@@ -366,6 +431,11 @@ func CullLinesByNeighbors(lines []Line, neighbors [][]int) []Line {
     return keep
 }
 
+// Lines for a board built from points and neighbors
+func MakeLines(points []Point, neighbors [][]int) ([]Line, error) {
+    return ExtractLines(points, neighbors, DefaultLineOptions)
+}
+
 func MakeTraditional(n int) *Board {
     points := make([]Point, n * n)
     for r := 0; r < n; r++ {
@@ -386,11 +456,7 @@ func MakeTraditional(n int) *Board {
     }
     // Cull lines with only two points
     keep = CullShortLines(keep)
-    return &Board{
-        Points: points,
-        Lines: keep,
-        Turn: 0,
-    }
+    return NewBoard(points, keep)
 }
 
 func (board *Board) Clone() *Board {
@@ -399,7 +465,12 @@ func (board *Board) Clone() *Board {
     b := &Board{
         Points: points,
         Lines: board.Lines,
+        Neighbors: board.Neighbors,
         Turn: board.Turn,
+        index: board.index,
+        geo: board.geo,
+        hash: board.hash,
+        hashed: board.hashed,
     }
     return b
 }
@@ -452,27 +523,53 @@ func (board *Board) CaptureForwards(ids []int, i int, me int, capture bool) bool
     return false
 }
 
+// Check only the lines through the point
+func (board *Board) canCapture(id int, me int) bool {
+    for _,lp := range board.lineIndex()[id] {
+        ids := board.Lines[lp.Line].Ids
+        if board.CaptureBackwards(ids, lp.Pos-1, me, false) ||
+            board.CaptureForwards(ids, lp.Pos+1, me, false) {
+            return true
+        }
+    }
+    return false
+}
+
+func (board *Board) hasMoves(me int) bool {
+    for pId,p := range board.Points {
+        if p.Player == -1 && board.canCapture(pId, me) {
+            return true
+        }
+    }
+    return false
+}
+
 // Turn determines player
-// Candidates are empty spaces next to other player's pieces
+// Moves are empty points that capture along some line, in order of id
+// Pass is not included
 func (board *Board) GetPossibleMoves() []int {
     me := board.Turn % 2
     moves := []int{}
-    for _,line := range board.Lines {
-        for i,pId := range line.Ids {
-            if board.Points[pId].Player != -1 {
-                continue
-            }
-            if board.CaptureBackwards(line.Ids, i-1, me, false) || 
-                board.CaptureForwards(line.Ids, i+1, me, false) {
-                moves = append(moves, pId)
-            }
+    for pId,p := range board.Points {
+        if p.Player != -1 {
+            continue
+        }
+        if board.canCapture(pId, me) {
+            moves = append(moves, pId)
         }
     }
     return moves
 }
 
+// Game ends when neither player can move
 func (board *Board) GameOver() bool {
-    return len(board.GetPossibleMoves()) == 0
+    return !board.hasMoves(0) && !board.hasMoves(1)
+}
+
+// Player to move has no moves but the other player does
+func (board *Board) MustPass() bool {
+    me := board.Turn % 2
+    return !board.hasMoves(me) && board.hasMoves(1-me)
 }
 
 // Count number of pieces of a player
@@ -501,43 +598,104 @@ func (board *Board) GetScores() [2]int {
 }
 
 func (board *Board) MoveIsLegal(to int) bool {
-    moves := board.GetPossibleMoves()
-    for _,move := range moves {
-        if move == to {
-            return true
-        }
+    if to == Pass {
+        return board.MustPass()
     }
-    return false
+    if to < 0 || to >= len(board.Points) || board.Points[to].Player != -1 {
+        return false
+    }
+    return board.canCapture(to, board.Turn % 2)
 }
 
 func (board *Board) Premove(to int, me int) {
+    h := board.stoneHash()
+    if p := board.Points[to].Player; p == 0 || p == 1 {
+        h ^= ZobristKey(p, to)
+    }
     board.Points[to].Player = me
+    board.hash = h ^ ZobristKey(me, to)
+}
+
+// Four stones on two lines that cross away from their ends, like the middle
+// of a traditional board, for boards built from a plan
+// Returns the points of the black and white stones, nil if no lines cross
+// that way. The choice follows the order of the lines.
+func (board *Board) PlaceStart() ([]int, []int) {
+    for id := range board.Points {
+        lps := board.PointLines(id)
+        for a := 0; a < len(lps); a++ {
+            for c := a+1; c < len(lps); c++ {
+                l1, p := board.Lines[lps[a].Line].Ids, lps[a].Pos
+                l2, q := board.Lines[lps[c].Line].Ids, lps[c].Pos
+                if p < 2 || p > len(l1)-3 || q < 2 || q > len(l2)-3 {
+                    continue
+                }
+                // Bifurcating lines share points
+                if Includes(l2, l1[p+1]) || Includes(l1, l2[q-1]) || Includes(l1, l2[q+1]) {
+                    continue
+                }
+                black := []int{id, l2[q+1]}
+                white := []int{l1[p+1], l2[q-1]}
+                for _,b := range black {
+                    board.Premove(b, 0)
+                }
+                for _,w := range white {
+                    board.Premove(w, 1)
+                }
+                return black, white
+            }
+        }
+    }
+    return nil, nil
 }
 
-func (board *Board) MakeMove(to int) {
+// Stones of the other player a move captures, without duplicates
+// Now that lines have bifurcations we can longer check for legality and capture
+// in the same loop
+// Every flip is collected before making any, lines that share stones would
+// otherwise block each other's captures
+func (board *Board) Flips(to int) []int {
+    flips := make([]int, 0)
+    if to == Pass {
+        return flips
+    }
     me := board.Turn % 2
-    // Now that lines have bifurcations we can longer check for legality and capture
-    // in the same loop
-    bwd := make([][2]int, 0)
-    fwd := make([][2]int, 0)
-    for j,line := range board.Lines {
-        for i,pId := range line.Ids {
-            if pId == to {
-                if board.CaptureBackwards(line.Ids, i-1, me, false) {
-                    bwd = append(bwd, [2]int{j, i})
-                }
-                if board.CaptureForwards(line.Ids, i+1, me, false) {
-                    fwd = append(fwd, [2]int{j, i})
-                }
+    add := func(id int) {
+        // Bifurcating lines can flip the same stone twice
+        if !Includes(flips, id) {
+            flips = append(flips, id)
+        }
+    }
+    for _,lp := range board.lineIndex()[to] {
+        ids := board.Lines[lp.Line].Ids
+        if board.CaptureBackwards(ids, lp.Pos-1, me, false) {
+            for i := lp.Pos-1; board.Points[ids[i]].Player == 1-me; i-- {
+                add(ids[i])
+            }
+        }
+        if board.CaptureForwards(ids, lp.Pos+1, me, false) {
+            for i := lp.Pos+1; board.Points[ids[i]].Player == 1-me; i++ {
+                add(ids[i])
             }
         }
     }
-    for _,lp := range bwd {
-        board.CaptureBackwards(board.Lines[lp[0]].Ids, lp[1]-1, me, true)
+    return flips
+}
+
+func (board *Board) MakeMove(to int) {
+    if to == Pass {
+        board.Turn += 1
+        return
     }
-    for _,lp := range fwd {
-        board.CaptureForwards(board.Lines[lp[0]].Ids, lp[1]+1, me, true)
+    me := board.Turn % 2
+    flips := board.Flips(to)
+    h := board.stoneHash() ^ ZobristKey(me, to)
+    board.Points[to].Player = me
+    for _,id := range flips {
+        h ^= ZobristKey(me, id) ^ ZobristKey(1-me, id)
+        board.Points[id].Player = me
     }
+    board.hash = h
     board.Turn += 1
 }
 
@@ -548,6 +706,9 @@ func (board *Board) GetCandidates() []func() *Board {
         return cand
     }
     moves := board.GetPossibleMoves()
+    if len(moves) == 0 && board.MustPass() {
+        moves = append(moves, Pass)
+    }
     for _,move := range moves {
         m := move
         cand = append(cand, func() *Board {
@@ -0,0 +1,52 @@
+package ai
+
+// Perft counts the positions after depth plies, to check move generation
+// against known counts. When the player to move has no moves but the
+// opponent does, the pass is a ply. A finished game is a leaf and counts
+// once whatever the depth left.
+func Perft(board *Board, depth int) uint64 {
+    if depth == 0 {
+        return 1
+    }
+    moves := board.GetPossibleMoves()
+    if len(moves) == 0 {
+        if board.GameOver() {
+            return 1
+        }
+        moves = append(moves, Pass)
+    }
+    if depth == 1 {
+        return uint64(len(moves))
+    }
+    var nodes uint64
+    for _,move := range moves {
+        next := board.Clone()
+        next.MakeMove(move)
+        nodes += Perft(next, depth-1)
+    }
+    return nodes
+}
+
+// Perft with the bitset move generator of the searches
+func PerftPosition(geo *Geometry, pos *Position, depth int) uint64 {
+    if depth == 0 {
+        return 1
+    }
+    moves := geo.Moves(pos, nil)
+    if len(moves) == 0 {
+        if geo.GameOver(pos) {
+            return 1
+        }
+        moves = append(moves, Pass)
+    }
+    if depth == 1 {
+        return uint64(len(moves))
+    }
+    var nodes uint64
+    for _,move := range moves {
+        next := *pos
+        geo.MakeMove(&next, move)
+        nodes += PerftPosition(geo, &next, depth-1)
+    }
+    return nodes
+}
@@ -0,0 +1,174 @@
+package ai
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+var updatePerft = flag.Bool("update", false, "rewrite the perft golden files")
+
+// Golden files stop before the first depth over this many nodes
+const perftMaxNodes = 100000
+
+// Boards of the golden files in testdata/perft, "" is the standard 8x8 start
+var perftBoards = []string{
+    "",
+    "David's Original Board",
+    "tripools with hexcenters",
+    "A nice little board",
+    "10x Almost Classic",
+    "Classic Altered",
+    "MomBoard",
+}
+
+func perftFile(name string) string {
+    if name == "" {
+        name = "traditional 8"
+    }
+    slug := strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+            return r
+        }
+        if r >= 'A' && r <= 'Z' {
+            return r - 'A' + 'a'
+        }
+        return '-'
+    }, name)
+    return filepath.Join("testdata", "perft", slug + ".txt")
+}
+
+// Points of each player's stones, in order
+func perftSetup(board *Board) [2][]int {
+    setup := [2][]int{{}, {}}
+    for id,p := range board.Points {
+        if p.Player == 0 || p.Player == 1 {
+            setup[p.Player] = append(setup[p.Player], id)
+        }
+    }
+    return setup
+}
+
+// Stones of the start position and node counts by depth, from 1
+// The start position is on "black" and "white" lines of point ids
+func readPerft(t *testing.T, file string) ([2][]int, []uint64) {
+    f, err := os.Open(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    setup := [2][]int{{}, {}}
+    counts := make([]uint64, 0)
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || text[0] == '#' {
+            continue
+        }
+        fields := strings.Fields(text)
+        if fields[0] == "black" || fields[0] == "white" {
+            player := 0
+            if fields[0] == "white" {
+                player = 1
+            }
+            for _,f := range fields[1:] {
+                id, err := strconv.Atoi(f)
+                if err != nil {
+                    t.Fatalf("%s: bad line %q", file, text)
+                }
+                setup[player] = append(setup[player], id)
+            }
+            continue
+        }
+        if len(fields) != 2 {
+            t.Fatalf("%s: bad line %q", file, text)
+        }
+        depth, err := strconv.Atoi(fields[0])
+        if err != nil || depth != len(counts)+1 {
+            t.Fatalf("%s: depth %s out of order", file, fields[0])
+        }
+        n, err := strconv.ParseUint(fields[1], 10, 64)
+        if err != nil {
+            t.Fatal(err)
+        }
+        counts = append(counts, n)
+    }
+    return setup, counts
+}
+
+func writePerft(t *testing.T, file string, name string, board *Board) {
+    if name == "" {
+        name = "MakeTraditional(8)"
+    }
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "# Perft of %s, regenerate with go test -run TestPerft -update\n", name)
+    fmt.Fprintln(&sb, "# The start position comes from the order of the lines, see Board.PlaceStart")
+    setup := perftSetup(board)
+    for player,color := range []string{"black", "white"} {
+        fmt.Fprint(&sb, color)
+        for _,id := range setup[player] {
+            fmt.Fprintf(&sb, " %d", id)
+        }
+        fmt.Fprintln(&sb)
+    }
+    for depth := 1; depth <= 20; depth++ {
+        n := Perft(board.Clone(), depth)
+        if n > perftMaxNodes {
+            break
+        }
+        fmt.Fprintf(&sb, "%d %d\n", depth, n)
+    }
+    if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(file, []byte(sb.String()), 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestPerft(t *testing.T) {
+    for _,name := range perftBoards {
+        board := benchBoard(t, name)
+        file := perftFile(name)
+        if *updatePerft {
+            writePerft(t, file, name, board)
+        }
+        setup, counts := readPerft(t, file)
+        // Counts of a different start position mean nothing, a change to
+        // the lines has to be looked at before the file is regenerated
+        if got := perftSetup(board); !reflect.DeepEqual(got, setup) {
+            t.Errorf("%s: start position is black %v white %v, expected black %v white %v", file, got[0], got[1], setup[0], setup[1])
+            continue
+        }
+        for i,want := range counts {
+            depth := i+1
+            if testing.Short() && want > 10000 {
+                break
+            }
+            if got := Perft(board.Clone(), depth); got != want {
+                t.Errorf("%s: perft %d is %d, expected %d", file, depth, got, want)
+            }
+            pos := board.Position()
+            if got := PerftPosition(board.Geometry(), &pos, depth); got != want {
+                t.Errorf("%s: bitset perft %d is %d, expected %d", file, depth, got, want)
+            }
+        }
+    }
+}
+
+// Published counts for the standard start
+func TestPerftTraditional(t *testing.T) {
+    want := []uint64{4, 12, 56, 244, 1396, 8200, 55092}
+    board := benchBoard(t, "")
+    for i,n := range want {
+        if got := Perft(board.Clone(), i+1); got != n {
+            t.Errorf("perft %d is %d, expected %d", i+1, got, n)
+        }
+    }
+}
@@ -0,0 +1,213 @@
+package ai
+
+import (
+    "math"
+    "sort"
+)
+
+// Lines that follow the tiles instead of angles
+//
+// Going around a point there are edges and tiles in turn. A line that comes
+// in through one of them leaves through the one halfway round: an edge takes
+// it to the neighbor, a tile with an even number of sides to the corner of
+// the tile opposite the point, and a tile with an odd number of sides ends
+// it. On a grid of squares that gives rows, columns and diagonals, on a grid
+// of triangles the three directions.
+//
+// Points on the rim are missing tiles. The gap is taken to hold as many
+// tiles as fit in its angle, at the mean angle of the tiles at the point,
+// and a line that leaves through the gap ends.
+
+// Something around a point: an edge to a neighbor, a tile, or a missing
+// tile or edge outside the board
+type slot struct {
+    to int
+    poly *polygon
+}
+
+// Not a point
+const outside = -1
+
+// Ids of the corners of each polygon in order
+func (t *Tiling) polyIds() map[*polygon][]int {
+    ids := make(map[*polygon][]int)
+    for i,p := range t.points {
+        for _,poly := range p.polys {
+            if ids[poly] == nil {
+                ids[poly] = make([]int, poly.n)
+            }
+            for k,e := range poly.edges {
+                if e[0].nearby(p.vec) {
+                    ids[poly][k] = i
+                }
+            }
+        }
+    }
+    return ids
+}
+
+// Edges and tiles around point i counterclockwise, starting from an edge
+func (t *Tiling) slots(i int) []slot {
+    p := t.points[i]
+    ns := append([]int{}, t.neighbors[i]...)
+    angle := func(j int) float64 {
+        return math.Atan2(t.points[j].Y - p.Y, t.points[j].X - p.X)
+    }
+    sort.Slice(ns, func(a, b int) bool {
+        return angle(ns[a]) < angle(ns[b])
+    })
+    mean := 0.0
+    for _,poly := range p.polys {
+        mean += thetaFromN(poly.n) / float64(len(p.polys))
+    }
+    slots := make([]slot, 0, 2*len(ns))
+    for k,j := range ns {
+        slots = append(slots, slot{j, nil})
+        next := ns[(k+1) % len(ns)]
+        gap := angle(next) - angle(j)
+        if gap <= 0 {
+            gap += 2*math.Pi
+        }
+        // The tile between the two edges, with its center inside the gap
+        var tile *polygon
+        for _,poly := range p.polys {
+            ps := poly.pointsNextTo(p.vec)
+            if len(ps) != 2 || !(ps[0].nearby(t.points[j].vec) && ps[1].nearby(t.points[next].vec) ||
+                ps[1].nearby(t.points[j].vec) && ps[0].nearby(t.points[next].vec)) {
+                continue
+            }
+            c := math.Atan2(poly.cp.Y - p.Y, poly.cp.X - p.X) - angle(j)
+            if c < 0 {
+                c += 2*math.Pi
+            }
+            if c < gap {
+                tile = poly
+                break
+            }
+        }
+        if tile != nil {
+            slots = append(slots, slot{outside, tile})
+            continue
+        }
+        missing := 1
+        if mean > 0 {
+            missing = int(jsRound(gap / mean))
+        }
+        if missing < 1 {
+            missing = 1
+        }
+        for m := 0; m < missing; m++ {
+            if m > 0 {
+                slots = append(slots, slot{outside, nil})
+            }
+            slots = append(slots, slot{outside, nil})
+        }
+    }
+    return slots
+}
+
+// Index of the edge to j or the tile poly around a point
+func slotOf(slots []slot, j int, poly *polygon) int {
+    for k,s := range slots {
+        if (poly == nil && s.poly == nil && s.to == j) || (poly != nil && s.poly == poly) {
+            return k
+        }
+    }
+    return -1
+}
+
+// Lines of the polygon model, windows of opts.MaxLength points if the
+// lines are longer
+//
+// Every edge and every line across an even tile is followed both ways until
+// it leaves the board or comes back to a point already on it. Each step
+// looks at the edges and tiles around one point, so with P points of degree
+// at most d this takes O(P * d * L) for lines of at most L points.
+func (t *Tiling) PolygonLines(opts LineOptions) []Line {
+    polyIds := t.polyIds()
+    slots := make([][]slot, len(t.points))
+    for i := range t.points {
+        slots[i] = t.slots(i)
+    }
+    // Next point after coming into i through slot in, and the slot it comes
+    // into that point through
+    step := func(i int, in int) (int, int) {
+        ss := slots[i]
+        out := ss[(in + len(ss)/2) % len(ss)]
+        switch {
+        case out.to != outside:
+            return out.to, slotOf(slots[out.to], i, nil)
+        case out.poly != nil && out.poly.n % 2 == 0:
+            ids := polyIds[out.poly]
+            k := 0
+            for ids[k] != i {
+                k++
+            }
+            j := ids[(k + out.poly.n/2) % out.poly.n]
+            return j, slotOf(slots[j], outside, out.poly)
+        }
+        return outside, -1
+    }
+    // Points after j going away from i, i came in to j through in
+    // Stops at points already on the line, both ways round a ring meet
+    follow := func(i int, j int, in int, on map[int]bool) []int {
+        ray := []int{}
+        for {
+            next, nin := step(j, in)
+            if next == outside || nin < 0 || on[next] {
+                return ray
+            }
+            ray = append(ray, next)
+            on[next] = true
+            j, in = next, nin
+        }
+    }
+    found := make([][]int, 0)
+    add := func(i int, j int, poly *polygon) {
+        on := map[int]bool{i: true, j: true}
+        back := follow(j, i, slotOf(slots[i], j, poly), on)
+        fwd := follow(i, j, slotOf(slots[j], i, poly), on)
+        line := make([]int, 0, len(back)+len(fwd)+2)
+        for k := len(back)-1; k >= 0; k-- {
+            line = append(line, back[k])
+        }
+        line = append(line, i, j)
+        line = append(line, fwd...)
+        if opts.MaxLength <= 0 || len(line) <= opts.MaxLength {
+            found = append(found, line)
+            return
+        }
+        for k := 0; k + opts.MaxLength <= len(line); k++ {
+            found = append(found, append([]int{}, line[k:k+opts.MaxLength]...))
+        }
+    }
+    for i := range t.points {
+        for _,j := range t.neighbors[i] {
+            if i < j {
+                add(i, j, nil)
+            }
+        }
+    }
+    for poly,ids := range polyIds {
+        if poly.n % 2 != 0 {
+            continue
+        }
+        for k := 0; k < poly.n/2; k++ {
+            add(ids[k], ids[k + poly.n/2], poly)
+        }
+    }
+    return finishLines(found, len(t.points))
+}
+
+// Lines of the tiling by model
+// Polygon lines take little work, but boards with too many are still refused
+func (t *Tiling) Lines(model LineModel, opts LineOptions) ([]Line, error) {
+    if model == PolygonLines {
+        lines := t.PolygonLines(opts)
+        if err := opts.tooMany(len(lines)); err != nil {
+            return nil, err
+        }
+        return lines, nil
+    }
+    return ExtractLines(t.Points(), t.Neighbors(), opts)
+}
@@ -0,0 +1,96 @@
+package ai
+
+import (
+    "math"
+    "testing"
+)
+
+// Every step of every line goes the same way
+func straight(points []Point, line Line) bool {
+    ids := line.Ids
+    for k := 2; k < len(ids); k++ {
+        if math.Abs(turn(points, ids[k-2], ids[k-1], ids[k])) > 1e-6 {
+            return false
+        }
+    }
+    return true
+}
+
+func TestPolygonLines(t *testing.T) {
+    // A plus of squares has rows and columns of 5 and diagonals,
+    // a hexagon of triangles has rows of 5 in three directions
+    boards := map[string][2]int{
+        `[{"typ":"fill","sav":[{"n":4,"txt":"Squares"}]},{"typ":"fill","sav":[{"n":4,"txt":"Squares"}]}]`: [2]int{20, 5},
+        `[{"typ":"fill","sav":[{"n":3,"txt":"Triangles"}]},{"typ":"fill","sav":[{"n":3,"txt":"Triangles"}]}]`: [2]int{15, 5},
+    }
+    for plan,e := range boards {
+        board, _, err := MakeFromPlan(`{"lines":"polygon","plan":` + plan + `}`)
+        if err != nil {
+            t.Fatal(err)
+        }
+        longest := 0
+        for _,line := range board.Lines {
+            if !straight(board.Points, line) {
+                t.Errorf("line %v turns", line.Ids)
+            }
+            if len(line.Ids) > longest {
+                longest = len(line.Ids)
+            }
+        }
+        if len(board.Lines) != e[0] || longest != e[1] {
+            t.Errorf("got %v lines of up to %v, expect %v of up to %v", len(board.Lines), longest, e[0], e[1])
+        }
+    }
+}
+
+// Steps are edges, or cross an even tile corner to corner
+func TestPolygonLinesAcrossTiles(t *testing.T) {
+    spec, err := ParseBoard(loadTestPlan(t, "First Dodecagon Board"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    spec.Lines = PolygonLines
+    points, ns, lines, err := spec.Build(DefaultLineOptions)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _,line := range lines {
+        for k := 1; k < len(line.Ids); k++ {
+            a, b := line.Ids[k-1], line.Ids[k]
+            if Includes(ns[a], b) {
+                continue
+            }
+            across := false
+            for _,n := range []int{4, 6, 8, 12} {
+                if nearby(Distance(points[a], points[b]), 2*polyDistFromN(n)) {
+                    across = true
+                }
+            }
+            if !across {
+                t.Fatalf("line %v jumps from %v to %v", line.Ids, a, b)
+            }
+        }
+    }
+}
+
+func TestParseBoard(t *testing.T) {
+    plan := `[{"typ":"fill","sav":[{"n":4,"txt":"Squares"}]}]`
+    for text,model := range map[string]LineModel{
+        plan: AngleLines,
+        `{"plan":` + plan + `}`: AngleLines,
+        `{"lines":"polygon","plan":` + plan + `}`: PolygonLines,
+    } {
+        spec, err := ParseBoard(text)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if spec.Lines != model || string(spec.Plan) != plan {
+            t.Errorf("%v: got %v %s", text, spec.Lines, spec.Plan)
+        }
+    }
+    for _,text := range []string{`{"lines":"curvy","plan":` + plan + `}`, `{"lines":"polygon"}`} {
+        if _, err := ParseBoard(text); err == nil {
+            t.Errorf("%v: expected an error", text)
+        }
+    }
+}
@@ -0,0 +1,326 @@
+package ai
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// Text records of non-grid games, like PGN but with the board embedded
+//
+//  [Board "David's Original Board"]
+//  [Result "34-30"]
+//  point 0 -12.5 30
+//  neighbors 0 1 4 5
+//  line 0 1 2 3
+//  black 12 40
+//  white 13 39
+//  moves 11 pass 38
+//
+// Tags come first, then the points in id order, the neighbors of each
+// point if known, the lines, the starting stones placed with Premove and
+// the moves by point id. Blank lines and lines starting with # are
+// ignored. A Fingerprint tag, if set, must be the fingerprint of the
+// points, neighbors and lines.
+
+// Result of a game that isn't over
+const Unfinished = "*"
+
+type Tag struct {
+    Name string
+    Value string
+}
+
+type Record struct {
+    Tags []Tag
+    // Only X and Y are kept
+    Points []Point
+    // Of each point, nil if not known
+    Neighbors [][]int
+    Lines []Line
+    // Starting stones of black and white
+    Setup [2][]int
+    Moves []int
+}
+
+// Moves per line when encoding
+const recordMovesPerLine = 20
+
+// Start is the position before the first move, black to move
+func NewRecord(start *Board, moves []int) *Record {
+    rec := &Record{
+        Points: make([]Point, len(start.Points)),
+        Neighbors: start.Neighbors,
+        Lines: start.Lines,
+        Setup: [2][]int{make([]int, 0), make([]int, 0)},
+        Moves: moves,
+    }
+    for i,p := range start.Points {
+        rec.Points[i] = Point{X: p.X, Y: p.Y, Id: i, Player: -1}
+        if p.Player == 0 || p.Player == 1 {
+            rec.Setup[p.Player] = append(rec.Setup[p.Player], i)
+        }
+    }
+    return rec
+}
+
+// Empty if the tag isn't set
+func (rec *Record) Tag(name string) string {
+    for _,t := range rec.Tags {
+        if t.Name == name {
+            return t.Value
+        }
+    }
+    return ""
+}
+
+func (rec *Record) SetTag(name string, value string) {
+    for i := range rec.Tags {
+        if rec.Tags[i].Name == name {
+            rec.Tags[i].Value = value
+            return
+        }
+    }
+    rec.Tags = append(rec.Tags, Tag{name, value})
+}
+
+// Board with the starting stones, before any move
+func (rec *Record) Start() *Board {
+    points := make([]Point, len(rec.Points))
+    copy(points, rec.Points)
+    for i := range points {
+        points[i].Player = -1
+    }
+    board := NewBoard(points, rec.Lines)
+    board.Neighbors = rec.Neighbors
+    for me,ids := range rec.Setup {
+        for _,id := range ids {
+            board.Premove(id, me)
+        }
+    }
+    return board
+}
+
+// Error unless the record was played on a board that plays the same,
+// e.g. the board its Board tag names
+func (rec *Record) CheckBoard(board *Board) error {
+    got, expect := Fingerprint(len(rec.Points), rec.Neighbors, rec.Lines), board.Fingerprint()
+    if got != expect {
+        return fmt.Errorf("record fingerprint %s, board has %s", got, expect)
+    }
+    return nil
+}
+
+// Final score of a finished game as a Result, e.g. "34-30", or Unfinished
+func ScoreResult(board *Board) string {
+    if !board.GameOver() {
+        return Unfinished
+    }
+    scores := board.GetScores()
+    return fmt.Sprintf("%d-%d", scores[0], scores[1])
+}
+
+// Plays every move, checking that each is legal
+// A score Result must match the final position, other results aren't checked
+func (rec *Record) Replay() (*Board, error) {
+    n := len(rec.Points)
+    for _,line := range rec.Lines {
+        for _,id := range line.Ids {
+            if id < 0 || id >= n {
+                return nil, fmt.Errorf("line point %d out of range", id)
+            }
+        }
+    }
+    for _,ids := range rec.Setup {
+        for _,id := range ids {
+            if id < 0 || id >= n {
+                return nil, fmt.Errorf("setup point %d out of range", id)
+            }
+        }
+    }
+    if rec.Neighbors != nil && len(rec.Neighbors) != n {
+        return nil, fmt.Errorf("neighbors of %d points, expected %d", len(rec.Neighbors), n)
+    }
+    for _,ns := range rec.Neighbors {
+        for _,id := range ns {
+            if id < 0 || id >= n {
+                return nil, fmt.Errorf("neighbor %d out of range", id)
+            }
+        }
+    }
+    if fp := rec.Tag("Fingerprint"); fp != "" && fp != Fingerprint(n, rec.Neighbors, rec.Lines) {
+        return nil, fmt.Errorf("points and lines don't match fingerprint %s", fp)
+    }
+    board := rec.Start()
+    for i,move := range rec.Moves {
+        if !board.MoveIsLegal(move) {
+            return board, fmt.Errorf("move %d (%s) is illegal", i+1, moveString(move))
+        }
+        board.MakeMove(move)
+    }
+    result := rec.Tag("Result")
+    var b, w int
+    if _, err := fmt.Sscanf(result, "%d-%d", &b, &w); err == nil {
+        if got := ScoreResult(board); got != result {
+            return board, fmt.Errorf("result %s, board gives %s", result, got)
+        }
+    }
+    return board, nil
+}
+
+func moveString(move int) string {
+    if move == Pass {
+        return "pass"
+    }
+    return strconv.Itoa(move)
+}
+
+func formatFloat(f float64) string {
+    return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeIds(bw *bufio.Writer, keyword string, ids []int) {
+    bw.WriteString(keyword)
+    for _,id := range ids {
+        bw.WriteString(" ")
+        bw.WriteString(moveString(id))
+    }
+    bw.WriteString("\n")
+}
+
+func (rec *Record) Encode(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    for _,t := range rec.Tags {
+        fmt.Fprintf(bw, "[%s %s]\n", t.Name, strconv.Quote(t.Value))
+    }
+    for i,p := range rec.Points {
+        fmt.Fprintf(bw, "point %d %s %s\n", i, formatFloat(p.X), formatFloat(p.Y))
+    }
+    for i,ns := range rec.Neighbors {
+        writeIds(bw, "neighbors", append([]int{i}, ns...))
+    }
+    for _,line := range rec.Lines {
+        writeIds(bw, "line", line.Ids)
+    }
+    writeIds(bw, "black", rec.Setup[0])
+    writeIds(bw, "white", rec.Setup[1])
+    for i := 0; i < len(rec.Moves); i += recordMovesPerLine {
+        end := i + recordMovesPerLine
+        if end > len(rec.Moves) {
+            end = len(rec.Moves)
+        }
+        writeIds(bw, "moves", rec.Moves[i:end])
+    }
+    return bw.Flush()
+}
+
+func (rec *Record) String() string {
+    var sb strings.Builder
+    rec.Encode(&sb)
+    return sb.String()
+}
+
+func parseIds(fields []string, pass bool) ([]int, error) {
+    ids := make([]int, 0, len(fields))
+    for _,f := range fields {
+        if pass && f == "pass" {
+            ids = append(ids, Pass)
+            continue
+        }
+        id, err := strconv.Atoi(f)
+        if err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, nil
+}
+
+// Errors give the line number, Replay checks the moves
+func DecodeRecord(r io.Reader) (*Record, error) {
+    rec := &Record{Setup: [2][]int{make([]int, 0), make([]int, 0)}, Moves: make([]int, 0)}
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for n := 1; scanner.Scan(); n++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || text[0] == '#' {
+            continue
+        }
+        lineErr := func(err error) error {
+            return fmt.Errorf("line %d: %v", n, err)
+        }
+        if text[0] == '[' {
+            name, value, ok := strings.Cut(strings.TrimSuffix(text[1:], "]"), " ")
+            if !ok || !strings.HasSuffix(text, "]") {
+                return nil, lineErr(fmt.Errorf("bad tag %q", text))
+            }
+            v, err := strconv.Unquote(value)
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            rec.Tags = append(rec.Tags, Tag{name, v})
+            continue
+        }
+        fields := strings.Fields(text)
+        switch fields[0] {
+        case "point":
+            if len(fields) != 4 {
+                return nil, lineErr(fmt.Errorf("point needs an id and two coordinates"))
+            }
+            id, err := strconv.Atoi(fields[1])
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            if id != len(rec.Points) {
+                return nil, lineErr(fmt.Errorf("point %d out of order", id))
+            }
+            if id >= MaxPoints {
+                return nil, lineErr(fmt.Errorf("more than %d points", MaxPoints))
+            }
+            x, err := strconv.ParseFloat(fields[2], 64)
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            y, err := strconv.ParseFloat(fields[3], 64)
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            rec.Points = append(rec.Points, Point{X: x, Y: y, Id: id, Player: -1})
+        case "neighbors":
+            ids, err := parseIds(fields[1:], false)
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            if len(ids) == 0 || ids[0] != len(rec.Neighbors) {
+                return nil, lineErr(fmt.Errorf("neighbors out of order"))
+            }
+            rec.Neighbors = append(rec.Neighbors, ids[1:])
+        case "line", "black", "white", "moves":
+            ids, err := parseIds(fields[1:], fields[0] == "moves")
+            if err != nil {
+                return nil, lineErr(err)
+            }
+            switch fields[0] {
+            case "line":
+                rec.Lines = append(rec.Lines, Line{Ids: ids})
+            case "black":
+                rec.Setup[0] = append(rec.Setup[0], ids...)
+            case "white":
+                rec.Setup[1] = append(rec.Setup[1], ids...)
+            default:
+                rec.Moves = append(rec.Moves, ids...)
+            }
+        default:
+            return nil, lineErr(fmt.Errorf("unknown keyword %q", fields[0]))
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(rec.Points) == 0 {
+        return nil, fmt.Errorf("record has no points")
+    }
+    return rec, nil
+}
@@ -0,0 +1,110 @@
+package ai
+
+import (
+    "strings"
+    "testing"
+)
+
+// Deterministic game to the end with passes
+func recordGame(t *testing.T, name string) (*Board, []int, *Board) {
+    start := benchBoard(t, name).Clone()
+    board := start.Clone()
+    moves := make([]int, 0)
+    for i := 0; !board.GameOver(); i++ {
+        move := Pass
+        if legal := board.GetPossibleMoves(); len(legal) > 0 {
+            move = legal[(i*7) % len(legal)]
+        }
+        board.MakeMove(move)
+        moves = append(moves, move)
+    }
+    return start, moves, board
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+    for _,name := range []string{"", "A nice little board"} {
+        start, moves, end := recordGame(t, name)
+        rec := NewRecord(start, moves)
+        rec.SetTag("Board", name)
+        rec.SetTag("Black", "Player \"one\"")
+        rec.SetTag("Result", ScoreResult(end))
+        text := rec.String()
+        dec, err := DecodeRecord(strings.NewReader(text))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if dec.String() != text {
+            t.Fatalf("%q: record changed after decoding", name)
+        }
+        if dec.Tag("Black") != "Player \"one\"" || dec.Tag("Board") != name {
+            t.Errorf("%q: tags %v", name, dec.Tags)
+        }
+        board, err := dec.Replay()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if board.Hash() != end.Hash() || board.Turn != end.Turn {
+            t.Errorf("%q: replay differs", name)
+        }
+    }
+}
+
+func TestRecordIllegal(t *testing.T) {
+    start, moves, end := recordGame(t, "")
+    rec := NewRecord(start, moves)
+    rec.SetTag("Result", "64-0")
+    if _, err := rec.Replay(); err == nil {
+        t.Errorf("wrong result accepted")
+    }
+    rec.SetTag("Result", ScoreResult(end))
+    if _, err := rec.Replay(); err != nil {
+        t.Fatal(err)
+    }
+    rec.Moves[3] = rec.Moves[2]
+    if _, err := rec.Replay(); err == nil || !strings.Contains(err.Error(), "move 4") {
+        t.Errorf("got %v, expect move 4 illegal", err)
+    }
+}
+
+func TestRecordFingerprint(t *testing.T) {
+    start, moves, _ := recordGame(t, "A nice little board")
+    rec := NewRecord(start, moves)
+    rec.SetTag("Fingerprint", start.Fingerprint())
+    if _, err := rec.Replay(); err != nil {
+        t.Fatal(err)
+    }
+    if err := rec.CheckBoard(renumber(start, 1)); err != nil {
+        t.Fatal(err)
+    }
+    if err := rec.CheckBoard(benchBoard(t, "MomBoard")); err == nil {
+        t.Error("record matches another board")
+    }
+    ns := rec.Neighbors
+    rec.Neighbors = nil
+    if _, err := rec.Replay(); err == nil {
+        t.Error("missing neighbors accepted")
+    }
+    rec.Neighbors = ns
+    rec.Lines = rec.Lines[1:]
+    if _, err := rec.Replay(); err == nil {
+        t.Error("changed lines accepted")
+    }
+}
+
+func TestDecodeRecordErrors(t *testing.T) {
+    bad := []string{
+        "",
+        "[Board unquoted]\npoint 0 0 0",
+        "point 1 0 0",
+        "point 0 0",
+        "point 0 0 0\nline 0 x",
+        "point 0 0 0\nmoves pass 0 3.5",
+        "point 0 0 0\nfoo 1",
+        "point 0 0 0\nneighbors 1 0",
+    }
+    for _,text := range bad {
+        if _, err := DecodeRecord(strings.NewReader(text)); err == nil {
+            t.Errorf("%q decoded", text)
+        }
+    }
+}
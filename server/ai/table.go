@@ -0,0 +1,65 @@
+package ai
+
+// Transposition table for the search
+// Values are from the searching player's view, the player is part of the key
+
+// Entries in tables made by Search and Loop
+var TableSize = 1 << 18
+
+// Bound types
+const (
+    Exact = iota
+    Lower
+    Upper
+)
+
+// Small fields keep entries at 24 bytes
+type TableEntry struct {
+    Key uint64
+    Value float64
+    Move int32
+    Depth int16
+    Bound int8
+}
+
+type TranspositionTable struct {
+    entries []TableEntry
+    mask uint64
+}
+
+// Size is rounded down to a power of two
+func NewTranspositionTable(size int) *TranspositionTable {
+    n := 1
+    for n*2 <= size {
+        n *= 2
+    }
+    return &TranspositionTable{
+        entries: make([]TableEntry, n),
+        mask: uint64(n-1),
+    }
+}
+
+func (tt *TranspositionTable) Size() int {
+    return len(tt.entries)
+}
+
+func (tt *TranspositionTable) Clear() {
+    for i := range tt.entries {
+        tt.entries[i] = TableEntry{}
+    }
+}
+
+func (tt *TranspositionTable) Probe(key uint64) (TableEntry, bool) {
+    e := tt.entries[key & tt.mask]
+    // Zero keys are empty slots
+    return e, e.Key == key && key != 0
+}
+
+// Keeps a deeper entry for the same position, replaces any other
+func (tt *TranspositionTable) Store(key uint64, depth int, bound int, value float64, move int) {
+    e := &tt.entries[key & tt.mask]
+    if e.Key == key && int(e.Depth) > depth {
+        return
+    }
+    *e = TableEntry{key, value, int32(move), int16(depth), int8(bound)}
+}
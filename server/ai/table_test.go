@@ -0,0 +1,114 @@
+package ai
+
+import (
+    "context"
+    "math"
+    "testing"
+    "time"
+)
+
+func TestHashIncremental(t *testing.T) {
+    board := benchBoard(t, "").Clone()
+    start := board.Hash()
+    for i := 0; !board.GameOver(); i++ {
+        moves := board.GetPossibleMoves()
+        move := Pass
+        if len(moves) > 0 {
+            move = moves[(i*5) % len(moves)]
+        }
+        board.MakeMove(move)
+        pos := board.Position()
+        fresh := board.Clone()
+        fresh.Rehash()
+        if board.Hash() != fresh.Hash() || board.Hash() != pos.Hash() {
+            t.Fatalf("move %v: hash %x, expect %x", move, board.Hash(), fresh.Hash())
+        }
+        if board.Hash() == start {
+            t.Fatalf("move %v: hash unchanged", move)
+        }
+    }
+}
+
+// Clones only read the board, so -race finds nothing here
+func TestCloneConcurrent(t *testing.T) {
+    board := MakeTraditional(8)
+    hashes := make(chan uint64, 4)
+    for i := 0; i < cap(hashes); i++ {
+        go func() {
+            clone := board.Clone()
+            clone.GetPossibleMoves()
+            hashes <- clone.Hash()
+        }()
+    }
+    for i := 0; i < cap(hashes); i++ {
+        if h := <-hashes; h != board.Hash() {
+            t.Fatalf("clone hash %x, expect %x", h, board.Hash())
+        }
+    }
+}
+
+func TestTranspositionTable(t *testing.T) {
+    tt := NewTranspositionTable(1000)
+    if tt.Size() != 512 {
+        t.Errorf("got %v, expect %v", tt.Size(), 512)
+    }
+    tt.Store(12345, 3, Lower, 1.5, 7)
+    tt.Store(12345, 2, Exact, 0, 8)
+    e, ok := tt.Probe(12345)
+    if !ok || e.Depth != 3 || e.Move != 7 {
+        t.Errorf("got %v, expect deeper entry kept", e)
+    }
+    if _, ok := tt.Probe(12345 + 512); ok {
+        t.Errorf("probe of other key hit")
+    }
+    tt.Store(12345 + 512, 1, Exact, 0, 9)
+    if e, _ := tt.Probe(12345 + 512); e.Move != 9 {
+        t.Errorf("got %v, expect other key replaced", e)
+    }
+    tt.Clear()
+    if _, ok := tt.Probe(12345 + 512); ok {
+        t.Errorf("hit after clear")
+    }
+}
+
+// Fixed depth values don't change with a table, even one full of shallower searches
+func TestSearchTableValue(t *testing.T) {
+    for _,name := range []string{"", "David's Original Board"} {
+        board := benchBoard(t, name)
+        tt := NewTranspositionTable(1 << 16)
+        for depth := 1; depth <= 5; depth++ {
+            _, _, _, expect := SearchDeepAlphaBeta(context.Background(), board.Clone(), 0, depth, math.Inf(-1), math.Inf(1), true, time.Now(), 100000, nil, nil)
+            _, _, _, got := SearchDeepAlphaBeta(context.Background(), board.Clone(), 0, depth, math.Inf(-1), math.Inf(1), true, time.Now(), 100000, tt, nil)
+            if got != expect {
+                t.Errorf("%v depth %v: got %v, expect %v", name, depth, got, expect)
+            }
+        }
+    }
+}
+
+func benchDeepening(b *testing.B, name string, depth int, size int) {
+    start := benchBoard(b, name)
+    b.ResetTimer()
+    nodes := 0
+    for n := 0; n < b.N; n++ {
+        var tt *TranspositionTable
+        if size > 0 {
+            tt = NewTranspositionTable(size)
+        }
+        for d := 1; d <= depth; d++ {
+            s := newSearcher(context.Background(), start.Geometry(), 0, d, time.Now(), 1000000, tt, nil)
+            pos := start.Position()
+            s.alphaBeta(&pos, d, math.Inf(-1), math.Inf(1), true)
+            nodes += s.nodes
+        }
+    }
+    b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+}
+
+func BenchmarkDeepeningTraditional8(b *testing.B) {
+    benchDeepening(b, "", 8, 0)
+}
+
+func BenchmarkDeepeningTableTraditional8(b *testing.B) {
+    benchDeepening(b, "", 8, 1 << 18)
+}
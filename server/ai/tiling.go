@@ -0,0 +1,563 @@
+package ai
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "os"
+    "sort"
+    "strings"
+)
+
+// Go port of the tiling builder in static/js/board.js and primitives.js
+// Executing a board plan here gives the same points (in the same order)
+// and neighbor lists that the client sends with NewGame
+
+const EdgeLen = 40.0
+
+// The client canvas is 800x800 and the first vertex goes in its center
+const planCenter = 400.0
+
+type PlanShape struct {
+    N int `json:"n"`
+    Txt string `json:"txt"`
+}
+
+type PlanStep struct {
+    Typ string `json:"typ"`
+    Sav []PlanShape `json:"sav"`
+}
+
+type vec struct {
+    X float64
+    Y float64
+}
+
+func (a vec) add(b vec) vec {
+    return vec{a.X + b.X, a.Y + b.Y}
+}
+
+func (a vec) sub(b vec) vec {
+    return vec{a.X - b.X, a.Y - b.Y}
+}
+
+func (a vec) mult(m float64) vec {
+    return vec{a.X * m, a.Y * m}
+}
+
+func (a vec) mag() float64 {
+    return math.Sqrt(a.X*a.X + a.Y*a.Y)
+}
+
+func (a vec) dist(b vec) float64 {
+    return a.sub(b).mag()
+}
+
+func (a vec) nearby(b vec) bool {
+    return a.dist(b) < 1e-3
+}
+
+func (a vec) rotate(theta float64) vec {
+    return vec{a.X*math.Cos(theta) - a.Y*math.Sin(theta), a.X*math.Sin(theta) + a.Y*math.Cos(theta)}
+}
+
+func nearby(a float64, b float64) bool {
+    return math.Abs(a-b) < 1e-3
+}
+
+func thetaFromN(n int) float64 {
+    return math.Pi - 2*math.Pi/float64(n)
+}
+
+func polyDistFromN(n int) float64 {
+    theta := 2*math.Pi/float64(n)
+    return math.Sqrt(EdgeLen*EdgeLen/2/(1-math.Cos(theta)))
+}
+
+func ccw(a vec, b vec, c vec) float64 {
+    return (b.X - a.X) * (c.Y - a.Y) - (c.X - a.X) * (b.Y - a.Y)
+}
+
+type polygon struct {
+    n int
+    cp vec
+    edges [][2]vec
+}
+
+// Center point, edge point, number of edges
+func newPolygon(cp vec, ep vec, n int) *polygon {
+    poly := &polygon{n: n, cp: cp}
+    theta := (math.Pi - 2*math.Pi/float64(n)) / 2
+    for i := 0; i < n; i++ {
+        d := cp.sub(ep)
+        d2 := d.mult(EdgeLen/d.mag())
+        np := d2.rotate(theta).add(ep)
+        poly.edges = append(poly.edges, [2]vec{ep, np})
+        ep = np
+    }
+    return poly
+}
+
+// Point inside the polygon
+func (poly *polygon) contains(p vec) bool {
+    for _,e := range poly.edges {
+        ref := 1
+        if ccw(e[0], e[1], poly.cp) <= 0 {
+            ref = -1
+        }
+        trial := 1
+        if ccw(e[0], e[1], p) <= 0 {
+            trial = -1
+        }
+        if ref != trial {
+            return false
+        }
+    }
+    return true
+}
+
+// One of the points on the edge of the polygon
+func (poly *polygon) edgeHas(p vec) bool {
+    for _,e := range poly.edges {
+        if e[0].nearby(p) || e[1].nearby(p) {
+            return true
+        }
+    }
+    return false
+}
+
+func (poly *polygon) pointsNextTo(p vec) []vec {
+    ps := make([]vec, 0, 2)
+    for _,e := range poly.edges {
+        if e[0].nearby(p) {
+            ps = append(ps, e[1])
+        } else if e[1].nearby(p) {
+            ps = append(ps, e[0])
+        }
+    }
+    return ps
+}
+
+type vertex struct {
+    vec
+    polys []*polygon
+}
+
+func (v *vertex) hasPoly(poly *polygon) bool {
+    return Includes(v.polys, poly)
+}
+
+// Get start and end angles around a polygon
+func getStartEndAngles(poly *polygon, p vec) (float64, float64) {
+    ps := poly.pointsNextTo(p)
+    d0, d1 := ps[0].sub(p), ps[1].sub(p)
+    t0 := math.Atan2(d0.Y, d0.X)
+    t1 := math.Atan2(d1.Y, d1.X)
+    if t0 < 0 {
+        t0 += 2*math.Pi
+    }
+    if t1 < 0 {
+        t1 += 2*math.Pi
+    }
+    // Wraparound
+    // Assume no polys take more than pi radians
+    if math.Abs(t0 - t1) > math.Pi {
+        if t0 < math.Pi {
+            t0 += 2*math.Pi
+        } else {
+            t1 += 2*math.Pi
+        }
+    }
+    // Start always less than end
+    if t1 < t0 {
+        t0, t1 = t1, t0
+    }
+    return t0, t1
+}
+
+// Get all taken and free angles around a point from 0 to 2pi
+func getFreeAngles(p *vertex) ([]float64, []float64, []float64) {
+    starts := make([]float64, 0, len(p.polys))
+    ends := make([]float64, 0, len(p.polys))
+    free := make([]float64, 0, len(p.polys))
+    for _,poly := range p.polys {
+        start, end := getStartEndAngles(poly, p.vec)
+        starts = append(starts, start)
+        ends = append(ends, end)
+    }
+    sort.Float64s(starts)
+    sort.Float64s(ends)
+    for i := range ends {
+        var td float64
+        if i == len(ends)-1 {
+            td = starts[0] + 2*math.Pi - ends[i]
+        } else {
+            td = starts[i+1] - ends[i]
+        }
+        free = append(free, td)
+    }
+    return starts, ends, free
+}
+
+func pointFreeAngle(p *vertex) float64 {
+    sum := 0.0
+    for _,poly := range p.polys {
+        sum += thetaFromN(poly.n)
+    }
+    return 2*math.Pi - sum
+}
+
+// Math.round in javascript rounds halves up
+func jsRound(a float64) float64 {
+    return math.Floor(a + 0.5)
+}
+
+type Tiling struct {
+    polys []*polygon
+    points []*vertex
+    // Points that are never filled/placed on
+    nofill []*vertex
+    neighbors [][]int
+}
+
+func (t *Tiling) addPoly(poly *polygon) {
+    t.polys = append(t.polys, poly)
+    for _,e := range poly.edges {
+        donea, doneb := false, false
+        for _,p := range t.points {
+            if !donea && e[0].nearby(p.vec) {
+                if !p.hasPoly(poly) {
+                    p.polys = append(p.polys, poly)
+                }
+                donea = true
+            }
+            if !doneb && e[1].nearby(p.vec) {
+                if !p.hasPoly(poly) {
+                    p.polys = append(p.polys, poly)
+                }
+                doneb = true
+            }
+            if donea && doneb {
+                break
+            }
+        }
+        if !donea {
+            t.points = append(t.points, &vertex{e[0], []*polygon{poly}})
+        }
+        if !doneb {
+            t.points = append(t.points, &vertex{e[1], []*polygon{poly}})
+        }
+    }
+}
+
+func (t *Tiling) polyOverlapsTiling(poly *polygon) bool {
+    for _,p := range t.points {
+        if !poly.contains(p.vec) {
+            continue
+        }
+        if !poly.edgeHas(p.vec) {
+            return true
+        }
+    }
+    return false
+}
+
+func (t *Tiling) fill(p *vertex, m int, place bool) bool {
+    d := polyDistFromN(m)
+    theta := thetaFromN(m)
+    var ends, free []float64
+    // First vertex on board
+    if len(p.polys) == 0 {
+        ends, free = []float64{0}, []float64{2*math.Pi}
+    } else {
+        _, ends, free = getFreeAngles(p)
+    }
+    for i := range ends {
+        td := free[i]
+        if nearby(td, 0) {
+            continue
+        }
+        n := td/theta
+        nn := jsRound(n)
+        if !nearby(n, nn) {
+            return false
+        }
+        for j := 0; j < int(nn); j++ {
+            a := ends[i] + theta/2 + float64(j)*theta
+            cp := vec{p.X + d*math.Cos(a), p.Y + d*math.Sin(a)}
+            poly := newPolygon(cp, p.vec, m)
+            if place {
+                t.addPoly(poly)
+            } else if t.polyOverlapsTiling(poly) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// Add just one poly to a vertex
+// Different from fill because it skips free areas that are insufficiently large
+func (t *Tiling) placeOne(p *vertex, m int, place bool) bool {
+    d := polyDistFromN(m)
+    theta := thetaFromN(m)
+    var ends, free []float64
+    // First vertex on board
+    if len(p.polys) == 0 {
+        ends, free = []float64{0}, []float64{2*math.Pi}
+    } else {
+        _, ends, free = getFreeAngles(p)
+    }
+    for i := range ends {
+        td := free[i]
+        if nearby(td, 0) {
+            continue
+        }
+        n := td/theta
+        if nearby(n, 1) || n > 1 {
+            a := ends[i] + theta/2
+            cp := vec{p.X + d*math.Cos(a), p.Y + d*math.Sin(a)}
+            poly := newPolygon(cp, p.vec, m)
+            if place {
+                t.addPoly(poly)
+            } else if t.polyOverlapsTiling(poly) {
+                continue
+            }
+            return true
+        }
+    }
+    return false
+}
+
+func (t *Tiling) nextFromCenter() []*vertex {
+    cp := vec{planCenter, planCenter}
+    mind := math.Inf(1)
+    set := make([]*vertex, 0)
+    for _,p := range t.points {
+        if nearby(pointFreeAngle(p), 0) {
+            continue
+        }
+        // Never placed
+        if Includes(t.nofill, p) {
+            continue
+        }
+        d := cp.sub(p.vec).mag()
+        if math.Abs(d-mind) < 1e-3 {
+            set = append(set, p)
+        } else if d < mind {
+            mind = d
+            set = []*vertex{p}
+        }
+    }
+    sort.SliceStable(set, func(i, j int) bool {
+        return math.Atan2(set[i].Y - cp.Y, set[i].X - cp.X) < math.Atan2(set[j].Y - cp.Y, set[j].X - cp.X)
+    })
+    return set
+}
+
+// Shape n of -1 is never fill, 0 is skip
+func (t *Tiling) apply(typ string, n int, p *vertex, place bool) bool {
+    if n <= 0 {
+        return true
+    }
+    if typ == "fill" {
+        return t.fill(p, n, place)
+    }
+    return t.placeOne(p, n, place)
+}
+
+func (t *Tiling) loop(step PlanStep) error {
+    var points []*vertex
+    if len(t.points) == 0 {
+        points = []*vertex{&vertex{vec{planCenter, planCenter}, nil}}
+    } else {
+        points = t.nextFromCenter()
+    }
+    fns := step.Sav
+    for offset := 0; offset < len(fns); offset++ {
+        allgood := true
+        for i,p := range points {
+            fn := fns[(i+offset) % len(fns)]
+            if !t.apply(step.Typ, fn.N, p, false) {
+                allgood = false
+                break
+            }
+        }
+        if allgood {
+            for k,p := range points {
+                fn := fns[(k+offset) % len(fns)]
+                if fn.N == -1 {
+                    t.nofill = append(t.nofill, p)
+                }
+                if !t.apply(step.Typ, fn.N, p, true) {
+                    return errors.New("Failed to place polygon")
+                }
+            }
+            return nil
+        }
+    }
+    // No good placement found, the client skips the step too
+    return nil
+}
+
+func (t *Tiling) initNeighbors() {
+    t.neighbors = make([][]int, len(t.points))
+    for i,p1 := range t.points {
+        for j,p2 := range t.points {
+            if math.Abs(p1.dist(p2.vec) - EdgeLen) >= 0.01 {
+                continue
+            }
+            // Check that p1 and p2 are part of the same polygon
+            // It can be that they aren't (on edge of board)
+            found := false
+            for _,poly := range t.polys {
+                if poly.edgeHas(p1.vec) && poly.edgeHas(p2.vec) {
+                    found = true
+                    break
+                }
+            }
+            if !found {
+                continue
+            }
+            if !Includes(t.neighbors[i], j) {
+                t.neighbors[i] = append(t.neighbors[i], j)
+            }
+            if !Includes(t.neighbors[j], i) {
+                t.neighbors[j] = append(t.neighbors[j], i)
+            }
+        }
+    }
+}
+
+func ParsePlan(plan string) ([]PlanStep, error) {
+    var steps []PlanStep
+    err := json.Unmarshal([]byte(plan), &steps)
+    if err != nil {
+        return nil, err
+    }
+    for _,step := range steps {
+        for _,shape := range step.Sav {
+            if shape.N < -1 || shape.N == 1 || shape.N == 2 {
+                return nil, fmt.Errorf("Bad polygon in plan: %d", shape.N)
+            }
+        }
+    }
+    return steps, nil
+}
+
+func BuildTiling(steps []PlanStep) (*Tiling, error) {
+    t := &Tiling{}
+    for _,step := range steps {
+        err := t.loop(step)
+        if err != nil {
+            return nil, err
+        }
+    }
+    t.initNeighbors()
+    return t, nil
+}
+
+// Points are empty, players place the starting pieces themselves
+func (t *Tiling) Points() []Point {
+    points := make([]Point, len(t.points))
+    for i,p := range t.points {
+        points[i] = Point{p.X, p.Y, i, -1}
+    }
+    return points
+}
+
+func (t *Tiling) Neighbors() [][]int {
+    ns := make([][]int, len(t.neighbors))
+    for i := range t.neighbors {
+        ns[i] = make([]int, len(t.neighbors[i]))
+        copy(ns[i], t.neighbors[i])
+    }
+    return ns
+}
+
+// A board file is a plan, or an object with the plan and its line model
+// {"lines": "polygon", "plan": [...]}
+// Clients only ever see the plan
+type BoardSpec struct {
+    Lines LineModel `json:"lines"`
+    Plan json.RawMessage `json:"plan"`
+}
+
+func ParseBoard(text string) (*BoardSpec, error) {
+    if !strings.HasPrefix(strings.TrimSpace(text), "{") {
+        return &BoardSpec{Lines: AngleLines, Plan: json.RawMessage(text)}, nil
+    }
+    spec := &BoardSpec{}
+    err := json.Unmarshal([]byte(text), spec)
+    if err != nil {
+        return nil, err
+    }
+    if spec.Lines == "" {
+        spec.Lines = AngleLines
+    }
+    if spec.Lines != AngleLines && spec.Lines != PolygonLines {
+        return nil, fmt.Errorf("Bad line model in board: %q", spec.Lines)
+    }
+    if len(spec.Plan) == 0 {
+        return nil, errors.New("Board has no plan")
+    }
+    return spec, nil
+}
+
+// Points, neighbors and lines of the board
+func (spec *BoardSpec) Build(opts LineOptions) ([]Point, [][]int, []Line, error) {
+    steps, err := ParsePlan(string(spec.Plan))
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    t, err := BuildTiling(steps)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    lines, err := t.Lines(spec.Lines, opts)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return t.Points(), t.Neighbors(), lines, nil
+}
+
+// Plan may also be a board file
+func PlanToPoints(plan string) ([]Point, [][]int, error) {
+    spec, err := ParseBoard(plan)
+    if err != nil {
+        return nil, nil, err
+    }
+    steps, err := ParsePlan(string(spec.Plan))
+    if err != nil {
+        return nil, nil, err
+    }
+    t, err := BuildTiling(steps)
+    if err != nil {
+        return nil, nil, err
+    }
+    return t.Points(), t.Neighbors(), nil
+}
+
+// Empty board built from a plan or board file, like MakeTraditional
+func MakeFromPlan(plan string) (*Board, [][]int, error) {
+    spec, err := ParseBoard(plan)
+    if err != nil {
+        return nil, nil, err
+    }
+    points, ns, lines, err := spec.Build(DefaultLineOptions)
+    if err != nil {
+        return nil, nil, err
+    }
+    board := NewBoard(points, lines)
+    board.Neighbors = ns
+    return board, ns, nil
+}
+
+func LoadPlan(file string) (*Board, [][]int, error) {
+    dat, err := os.ReadFile(file)
+    if err != nil {
+        return nil, nil, err
+    }
+    return MakeFromPlan(string(dat))
+}
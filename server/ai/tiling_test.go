@@ -0,0 +1,79 @@
+package ai
+
+import (
+    "os"
+    "testing"
+)
+
+func loadTestPlan(t testing.TB, name string) string {
+    dat, err := os.ReadFile("../../boards/" + name)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(dat)
+}
+
+// Point and neighbor edge counts are from static/js/board.js
+func TestPlanToPoints(t *testing.T) {
+    expect := map[string][2]int{
+        "testboardseethis": [2]int{7, 12},
+        "10x Almost Classic": [2]int{117, 212},
+        "33412 start": [2]int{25, 31},
+        "David's Original Board": [2]int{100, 198},
+        "NeverPlaceTest": [2]int{61, 100},
+        "growing spiral 2": [2]int{367, 876},
+    }
+    for name,e := range expect {
+        points, ns, err := PlanToPoints(loadTestPlan(t, name))
+        if err != nil {
+            t.Fatal(err)
+        }
+        edges := 0
+        for i,n := range ns {
+            if points[i].Id != i || points[i].Player != -1 {
+                t.Errorf("%v: bad point %v", name, points[i])
+            }
+            for _,j := range n {
+                if !Includes(ns[j], i) {
+                    t.Errorf("%v: %v-%v not symmetric", name, i, j)
+                }
+            }
+            edges += len(n)
+        }
+        got := [2]int{len(points), edges/2}
+        if got != e {
+            t.Errorf("%v: got %v, expect %v", name, got, e)
+        }
+    }
+}
+
+func TestPlanToPointsFirstVertex(t *testing.T) {
+    // Six triangles around the center of the canvas
+    points, ns, err := PlanToPoints(`[{"typ":"fill","sav":[{"n":3,"txt":"Triangles"}]}]`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if points[0].X != 400 || points[0].Y != 400 {
+        t.Errorf("got %v, expect %v", points[0], Point{400,400,0,-1})
+    }
+    if len(ns[0]) != 6 {
+        t.Errorf("got %v, expect %v", len(ns[0]), 6)
+    }
+}
+
+func TestParsePlanBadShape(t *testing.T) {
+    _, err := ParsePlan(`[{"typ":"fill","sav":[{"n":2,"txt":"Digons"}]}]`)
+    if err == nil {
+        t.Errorf("expected error for n=2")
+    }
+}
+
+func TestMakeFromPlan(t *testing.T) {
+    board, _, err := MakeFromPlan(loadTestPlan(t, "10x Almost Classic"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(board.Points) != 117 || len(board.Lines) == 0 {
+        t.Errorf("got %v points %v lines", len(board.Points), len(board.Lines))
+    }
+}
@@ -0,0 +1,93 @@
+package ai
+
+import (
+    "fmt"
+    "math"
+)
+
+// Limits on client supplied boards
+// The largest shipped board has 367 points and no vertex has more than 6 neighbors
+const (
+    MaxPoints = 1024
+    MaxNeighbors = 12
+    MaxCoord = 1e6
+)
+
+// Field is Points or Neighbors, Index is the offending point
+type GeometryError struct {
+    Field string
+    Index int
+    Reason string
+}
+
+func (e *GeometryError) Error() string {
+    if e.Index < 0 {
+        return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+    }
+    return fmt.Sprintf("%s[%d]: %s", e.Field, e.Index, e.Reason)
+}
+
+func geometryErr(field string, index int, format string, args ...any) *GeometryError {
+    return &GeometryError{field, index, fmt.Sprintf(format, args...)}
+}
+
+// Check points and neighbors before generating lines
+// Ids must match slice indices, players must be -1, 0 or 1 and neighbors must be symmetric
+func ValidateGeometry(points []Point, neighbors [][]int) error {
+    if len(points) == 0 {
+        return geometryErr("Points", -1, "no points")
+    }
+    if len(points) > MaxPoints {
+        return geometryErr("Points", -1, "%d points exceeds limit of %d", len(points), MaxPoints)
+    }
+    if len(neighbors) != len(points) {
+        return geometryErr("Neighbors", -1, "%d neighbor lists for %d points", len(neighbors), len(points))
+    }
+    for i,p := range points {
+        if p.Id != i {
+            return geometryErr("Points", i, "id %d does not match index", p.Id)
+        }
+        if p.Player < -1 || p.Player > 1 {
+            return geometryErr("Points", i, "bad player %d", p.Player)
+        }
+        if math.IsNaN(p.X) || math.IsNaN(p.Y) || math.Abs(p.X) > MaxCoord || math.Abs(p.Y) > MaxCoord {
+            return geometryErr("Points", i, "bad coordinates (%v, %v)", p.X, p.Y)
+        }
+    }
+    for i,ns := range neighbors {
+        if len(ns) > MaxNeighbors {
+            return geometryErr("Neighbors", i, "%d neighbors exceeds limit of %d", len(ns), MaxNeighbors)
+        }
+        for k,j := range ns {
+            if j < 0 || j >= len(points) {
+                return geometryErr("Neighbors", i, "neighbor %d out of range", j)
+            }
+            if j == i {
+                return geometryErr("Neighbors", i, "point is its own neighbor")
+            }
+            if Includes(ns[:k], j) {
+                return geometryErr("Neighbors", i, "duplicate neighbor %d", j)
+            }
+            if !Includes(neighbors[j], i) {
+                return geometryErr("Neighbors", i, "neighbor %d does not list %d", j, i)
+            }
+            if Distance(points[i], points[j]) < 1e-3 {
+                return geometryErr("Neighbors", i, "neighbor %d at the same coordinates", j)
+            }
+        }
+    }
+    return nil
+}
+
+// Check that client points lie on the expected points, e.g. from PlanToPoints
+func MatchGeometry(points []Point, expect []Point) error {
+    if len(points) != len(expect) {
+        return geometryErr("Points", -1, "%d points, board has %d", len(points), len(expect))
+    }
+    for i := range points {
+        if Distance(points[i], expect[i]) > 0.01 {
+            return geometryErr("Points", i, "(%v, %v) not on board", points[i].X, points[i].Y)
+        }
+    }
+    return nil
+}
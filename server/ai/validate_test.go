@@ -0,0 +1,56 @@
+package ai
+
+import (
+    "testing"
+)
+
+func TestValidateGeometry(t *testing.T) {
+    points, ns, err := PlanToPoints(loadTestPlan(t, "testboardseethis"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := ValidateGeometry(points, ns); err != nil {
+        t.Errorf("got %v, expect nil", err)
+    }
+    if err := MatchGeometry(points, points); err != nil {
+        t.Errorf("got %v, expect nil", err)
+    }
+    clone := func() ([]Point, [][]int) {
+        ps := make([]Point, len(points))
+        copy(ps, points)
+        nns := make([][]int, len(ns))
+        for i := range ns {
+            nns[i] = append([]int{}, ns[i]...)
+        }
+        return ps, nns
+    }
+    ps, nns := clone()
+    ps[3].Id = 4
+    expectGeometryErr(t, ValidateGeometry(ps, nns), "Points", 3)
+    ps, nns = clone()
+    ps[2].Player = 2
+    expectGeometryErr(t, ValidateGeometry(ps, nns), "Points", 2)
+    ps, nns = clone()
+    nns[1] = append(nns[1], 100)
+    expectGeometryErr(t, ValidateGeometry(ps, nns), "Neighbors", 1)
+    ps, nns = clone()
+    nns[0] = nns[0][1:]
+    expectGeometryErr(t, ValidateGeometry(ps, nns), "Neighbors", ns[0][0])
+    ps, nns = clone()
+    expectGeometryErr(t, ValidateGeometry(ps, nns[1:]), "Neighbors", -1)
+    ps, _ = clone()
+    ps[5].X += 1
+    expectGeometryErr(t, MatchGeometry(ps, points), "Points", 5)
+}
+
+func expectGeometryErr(t *testing.T, err error, field string, index int) {
+    t.Helper()
+    gerr, ok := err.(*GeometryError)
+    if !ok {
+        t.Errorf("got %v, expect GeometryError", err)
+        return
+    }
+    if gerr.Field != field || gerr.Index != index {
+        t.Errorf("got %v, expect %v[%v]", gerr, field, index)
+    }
+}
@@ -0,0 +1,73 @@
+package ai
+
+// Zobrist keys for positions
+// Keys depend only on point id and player so boards, clones and
+// Positions of the same points always hash alike
+
+var zobristStones = makeZobristStones()
+
+// Side to move and searching player
+var zobristTurn = splitMix64(1 << 62)
+var zobristMe = splitMix64(1 << 63)
+
+func splitMix64(x uint64) uint64 {
+    x += 0x9e3779b97f4a7c15
+    x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+    x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+    return x ^ (x >> 31)
+}
+
+func makeZobristStones() []uint64 {
+    keys := make([]uint64, 2*MaxPoints)
+    for i := range keys {
+        keys[i] = splitMix64(uint64(i))
+    }
+    return keys
+}
+
+// Key of a stone of player at point id
+// Bigger boards than MaxPoints can't be searched but still hash
+func ZobristKey(player int, id int) uint64 {
+    i := 2*id + player
+    if id < MaxPoints {
+        return zobristStones[i]
+    }
+    return splitMix64(uint64(i))
+}
+
+// Stones only, the turn is added by Hash
+// Boards made without NewBoard hash on first use
+func (board *Board) stoneHash() uint64 {
+    if !board.hashed {
+        board.Rehash()
+    }
+    return board.hash
+}
+
+// Zobrist key of stones and side to move
+// Kept up to date by Premove and MakeMove, other changes to Points need Rehash
+func (board *Board) Hash() uint64 {
+    h := board.stoneHash()
+    if board.Turn % 2 == 1 {
+        h ^= zobristTurn
+    }
+    return h
+}
+
+func (board *Board) Rehash() {
+    board.hash = 0
+    for i,p := range board.Points {
+        if p.Player == 0 || p.Player == 1 {
+            board.hash ^= ZobristKey(p.Player, i)
+        }
+    }
+    board.hashed = true
+}
+
+func (pos *Position) Hash() uint64 {
+    h := pos.StoneHash
+    if pos.Turn % 2 == 1 {
+        h ^= zobristTurn
+    }
+    return h
+}
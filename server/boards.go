@@ -0,0 +1,161 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Fingerprints of the board files, see ai.Fingerprint
+// Per board ratings are kept by fingerprint, and boards that play the same
+// as one with a lower name are left out of ListBoards
+// Requests read fingerprints made by Build, only boards it doesn't know are
+// fingerprinted on the request goroutine
+type BoardIndex struct {
+    mutex sync.Mutex
+    prints map[string]boardPrint
+    // Closed when Build is done
+    built chan bool
+}
+
+// Fingerprint of a board file as it was at mod
+type boardPrint struct {
+    mod time.Time
+    fingerprint string
+}
+
+var boardIndex = NewBoardIndex()
+
+func NewBoardIndex() *BoardIndex {
+    return &BoardIndex{prints: make(map[string]boardPrint), built: make(chan bool)}
+}
+
+func boardMod(name string) time.Time {
+    info, err := os.Stat("../boards/" + name)
+    if err != nil {
+        return time.Time{}
+    }
+    return info.ModTime()
+}
+
+// Builds the board the first time and again when the file changes
+// Large boards take up to a second, see ai.DefaultLineOptions
+func (idx *BoardIndex) Fingerprint(name string) (string, error) {
+    mod := boardMod(name)
+    idx.mutex.Lock()
+    bp, ok := idx.prints[name]
+    idx.mutex.Unlock()
+    if ok && bp.mod.Equal(mod) {
+        return bp.fingerprint, nil
+    }
+    spec, err := GetBoardSpec(name)
+    if err != nil {
+        return "", err
+    }
+    points, ns, lines, err := spec.Build(ai.DefaultLineOptions)
+    if err != nil {
+        return "", Fail(ErrBadBoard, fmt.Sprintf("board %q: %v", name, err))
+    }
+    fp := ai.Fingerprint(len(points), ns, lines)
+    idx.mutex.Lock()
+    idx.prints[name] = boardPrint{mod, fp}
+    idx.mutex.Unlock()
+    return fp, nil
+}
+
+// Known fingerprint of a board, or "" if it hasn't been built yet
+func (idx *BoardIndex) known(name string) string {
+    idx.mutex.Lock()
+    defer idx.mutex.Unlock()
+    return idx.prints[name].fingerprint
+}
+
+func (idx *BoardIndex) Ready() bool {
+    select {
+    case <-idx.built:
+        return true
+    default:
+        return false
+    }
+}
+
+// Fingerprint made by Build, waits for it
+// Boards added or changed since have none until the next Build
+func (idx *BoardIndex) Lookup(name string) (string, error) {
+    <-idx.built
+    fp := idx.known(name)
+    if fp == "" {
+        return "", Fail(ErrNoBoard, fmt.Sprintf("no board %q", name))
+    }
+    return fp, nil
+}
+
+// Fingerprint of board, a game on the board file name
+// Comes from Build unless the file is new or changed since, waits for it
+func (idx *BoardIndex) GameFingerprint(name string, board *ai.Board) string {
+    <-idx.built
+    mod := boardMod(name)
+    idx.mutex.Lock()
+    bp, ok := idx.prints[name]
+    idx.mutex.Unlock()
+    if ok && bp.mod.Equal(mod) {
+        return bp.fingerprint
+    }
+    return board.Fingerprint()
+}
+
+// Leaves out boards that play the same as one with a lower name
+// Waits for Build, so the list doesn't change while it runs
+func (idx *BoardIndex) Dedupe(names []string) []string {
+    <-idx.built
+    first := make(map[string]string)
+    for _,name := range names {
+        fp := idx.known(name)
+        if fp == "" {
+            continue
+        }
+        if f, ok := first[fp]; !ok || name < f {
+            first[fp] = name
+        }
+    }
+    keep := make([]string, 0, len(names))
+    for _,name := range names {
+        fp := idx.known(name)
+        if fp != "" && first[fp] != name {
+            continue
+        }
+        keep = append(keep, name)
+    }
+    return keep
+}
+
+// Fingerprints the boards, smallest files first, and logs duplicates
+// Called once, Lookup and Dedupe wait for it
+func (idx *BoardIndex) Build(names []string) {
+    names = append([]string{}, names...)
+    size := func(name string) int64 {
+        info, err := os.Stat("../boards/" + name)
+        if err != nil {
+            return 0
+        }
+        return info.Size()
+    }
+    sort.Slice(names, func(i, j int) bool {
+        return size(names[i]) < size(names[j])
+    })
+    for _,name := range names {
+        if _, err := idx.Fingerprint(name); err != nil {
+            log.Println(err)
+        }
+    }
+    close(idx.built)
+    kept := idx.Dedupe(names)
+    if len(kept) < len(names) {
+        log.Printf("%d boards play the same as another board and aren't listed", len(names) - len(kept))
+    }
+}
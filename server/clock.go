@@ -0,0 +1,246 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+)
+
+// Time control modes
+const (
+    SuddenDeath = "sudden"
+    Fischer = "fischer"
+    ByoYomi = "byoyomi"
+)
+
+// Main time for each player, then for Fischer an increment after every
+// move, or for byo-yomi Periods periods of PeriodMillis each move must fit in
+type TimeControl struct {
+    Mode string
+    MainMillis int64
+    IncrementMillis int64
+    Periods int
+    PeriodMillis int64
+}
+
+// Longest time control accepted, per player
+const MaxClockMillis = 24 * 60 * 60 * 1000
+
+// Most byo-yomi periods accepted
+const MaxPeriods = 100
+
+func (tc *TimeControl) Validate() error {
+    switch tc.Mode {
+    case SuddenDeath, Fischer, ByoYomi:
+    default:
+        return errors.New("unknown time control " + tc.Mode)
+    }
+    if tc.MainMillis < 0 || tc.IncrementMillis < 0 || tc.Periods < 0 || tc.PeriodMillis < 0 {
+        return errors.New("negative time control")
+    }
+    // Bounded one by one first so the sum can't overflow
+    if tc.MainMillis > MaxClockMillis || tc.IncrementMillis > MaxClockMillis || tc.Periods > MaxPeriods || tc.PeriodMillis > MaxClockMillis {
+        return errors.New("time control too long")
+    }
+    if tc.MainMillis + tc.IncrementMillis + int64(tc.Periods) * tc.PeriodMillis > MaxClockMillis {
+        return errors.New("time control too long")
+    }
+    if tc.Mode == ByoYomi && (tc.Periods == 0 || tc.PeriodMillis == 0) {
+        return errors.New("byo-yomi needs periods")
+    }
+    if tc.Mode != ByoYomi && tc.MainMillis == 0 {
+        return errors.New("no main time")
+    }
+    return nil
+}
+
+// For game records
+func (tc *TimeControl) String() string {
+    switch tc.Mode {
+    case Fischer:
+        return fmt.Sprintf("%s %d+%d", tc.Mode, tc.MainMillis, tc.IncrementMillis)
+    case ByoYomi:
+        return fmt.Sprintf("%s %d+%dx%d", tc.Mode, tc.MainMillis, tc.Periods, tc.PeriodMillis)
+    }
+    return fmt.Sprintf("%s %d", tc.Mode, tc.MainMillis)
+}
+
+// Sent with every reply of a game with a clock
+type ClockState struct {
+    Mode string
+    // Main time left in milliseconds
+    Left [2]int64
+    // Byo-yomi periods left
+    Periods [2]int
+    // Seat whose time runs, -1 if none
+    Running int
+}
+
+// Guarded by the game lock
+// The exported fields are saved with the game
+type Clock struct {
+    Control TimeControl
+    Left [2]int64
+    Periods [2]int
+    Running int
+    since time.Time
+    timer *time.Timer
+}
+
+func NewClock(tc TimeControl) *Clock {
+    return &Clock{
+        Control: tc,
+        Left: [2]int64{tc.MainMillis, tc.MainMillis},
+        Periods: [2]int{tc.Periods, tc.Periods},
+        Running: -1,
+    }
+}
+
+// Time before seat loses if it doesn't move
+func (c *Clock) Remaining(seat int, now time.Time) int64 {
+    left := c.Left[seat]
+    if seat == c.Running {
+        left -= now.Sub(c.since).Milliseconds()
+    }
+    if c.Control.Mode == ByoYomi {
+        left += int64(c.Periods[seat]) * c.Control.PeriodMillis
+    }
+    return left
+}
+
+func (c *Clock) State(now time.Time) *ClockState {
+    state := &ClockState{Mode: c.Control.Mode, Left: c.Left, Periods: c.Periods, Running: c.Running}
+    if c.Running != -1 {
+        state.Left[c.Running] -= now.Sub(c.since).Milliseconds()
+    }
+    return state
+}
+
+// Charges the running seat, false if it ran out of time
+// Byo-yomi periods used up are lost, the period in progress is forgiven
+func (c *Clock) charge(now time.Time) bool {
+    seat := c.Running
+    if seat == -1 {
+        return true
+    }
+    if c.timer != nil {
+        c.timer.Stop()
+    }
+    c.Running = -1
+    left := c.Left[seat] - now.Sub(c.since).Milliseconds()
+    if left >= 0 {
+        c.Left[seat] = left
+        return true
+    }
+    c.Left[seat] = 0
+    if c.Control.Mode != ByoYomi {
+        return false
+    }
+    lost := int(-left / c.Control.PeriodMillis)
+    if lost >= c.Periods[seat] {
+        c.Periods[seat] = 0
+        return false
+    }
+    c.Periods[seat] -= lost
+    return true
+}
+
+// Stops the clock after seat moved, false if seat ran out of time first
+func (c *Clock) Press(seat int, now time.Time) bool {
+    if c.Running != seat {
+        return true
+    }
+    if !c.charge(now) {
+        return false
+    }
+    if c.Control.Mode == Fischer {
+        c.Left[seat] += c.Control.IncrementMillis
+    }
+    return true
+}
+
+// Starts the time of seat, flag is called in a new goroutine when it runs out
+func (c *Clock) Run(seat int, now time.Time, flag func()) {
+    c.Halt(now)
+    c.Running = seat
+    c.since = now
+    c.timer = time.AfterFunc(time.Duration(c.Remaining(seat, now) + 1) * time.Millisecond, flag)
+}
+
+// Stops the clock without an increment, e.g. at game over
+func (c *Clock) Halt(now time.Time) {
+    c.charge(now)
+}
+
+// Time an AI should spend on a move, spread over the moves it has left
+func (c *Clock) Budget(seat int, now time.Time, empties int) int {
+    left := c.Left[seat]
+    if seat == c.Running {
+        left -= now.Sub(c.since).Milliseconds()
+    }
+    movesLeft := int64(empties/2 + 4)
+    budget := left / movesLeft
+    switch c.Control.Mode {
+    case Fischer:
+        budget += c.Control.IncrementMillis * 3/4
+        if budget > left/2 {
+            budget = left/2
+        }
+    case ByoYomi:
+        if period := c.Control.PeriodMillis * 3/4; budget < period {
+            budget = period
+        }
+    }
+    if budget < 10 {
+        budget = 10
+    }
+    return int(budget)
+}
+
+// Methods below need the game locked
+
+// Starts the time of the player to move
+// A held clock stays stopped while that player is away
+func (game *Game) RunClock() {
+    if game.Clock == nil || game.GameOver {
+        return
+    }
+    seat := game.Board.Turn % 2
+    if game.held {
+        if seat != game.AISeat && game.Conns[seat] == nil {
+            return
+        }
+        game.held = false
+    }
+    game.Clock.Run(seat, time.Now(), func() {
+        game.flag(seat)
+    })
+}
+
+// False if player ran out of time before moving, the game is then over
+func (game *Game) PressClock(player int) bool {
+    if game.Clock == nil || game.Clock.Press(player, time.Now()) {
+        return true
+    }
+    game.Timeout(player)
+    return false
+}
+
+func (game *Game) Timeout(player int) {
+    game.Result = colorNames[player] + " loses on time"
+    game.Loser = player
+    game.Stop()
+    game.Broadcast(Reply{Action: "Timeout", Player: player, GameOver: true})
+}
+
+// Called by the clock when seat's time may have run out
+func (game *Game) flag(seat int) {
+    game.Lock()
+    defer game.Unlock()
+    if game.GameOver || game.Clock.Running != seat || game.Clock.Remaining(seat, time.Now()) > 0 {
+        return
+    }
+    log.Println("game", game.Key, colorNames[seat], "out of time")
+    game.Timeout(seat)
+}
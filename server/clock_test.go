@@ -0,0 +1,111 @@
+package main
+
+import (
+    "math"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestClock(t *testing.T) {
+    now := time.Now()
+    c := NewClock(TimeControl{Mode: Fischer, MainMillis: 1000, IncrementMillis: 500})
+    c.Run(0, now, func() {})
+    now = now.Add(300 * time.Millisecond)
+    if !c.Press(0, now) || c.Left[0] != 1200 || c.Running != -1 {
+        t.Fatalf("fischer left %d running %d", c.Left[0], c.Running)
+    }
+    c.Run(1, now, func() {})
+    if c.Press(1, now.Add(1500 * time.Millisecond)) {
+        t.Fatal("fischer flag")
+    }
+
+    c = NewClock(TimeControl{Mode: ByoYomi, MainMillis: 1000, Periods: 3, PeriodMillis: 1000})
+    c.Run(0, now, func() {})
+    if r := c.Remaining(0, now.Add(500 * time.Millisecond)); r != 3500 {
+        t.Fatalf("byo-yomi remaining %d", r)
+    }
+    // Main time and one period used up, the second period is forgiven
+    if !c.Press(0, now.Add(2500 * time.Millisecond)) || c.Left[0] != 0 || c.Periods[0] != 2 {
+        t.Fatalf("byo-yomi left %d periods %d", c.Left[0], c.Periods[0])
+    }
+    c.Run(0, now, func() {})
+    if c.Press(0, now.Add(2000 * time.Millisecond)) || c.Periods[0] != 0 {
+        t.Fatal("byo-yomi flag")
+    }
+
+    c = NewClock(TimeControl{Mode: SuddenDeath, MainMillis: 60000})
+    if b := c.Budget(1, now, 40); b != 2500 {
+        t.Fatalf("budget %d", b)
+    }
+    if err := (&TimeControl{Mode: ByoYomi, MainMillis: 1000}).Validate(); err == nil {
+        t.Fatal("byo-yomi without periods")
+    }
+    // Their sum used to overflow to a small number
+    for _,tc := range []TimeControl{
+        {Mode: ByoYomi, MainMillis: 1000, Periods: 2, PeriodMillis: math.MaxInt64/2 + 1},
+        {Mode: ByoYomi, MainMillis: 1000, Periods: math.MaxInt32, PeriodMillis: 1},
+        {Mode: Fischer, MainMillis: 1000, IncrementMillis: math.MaxInt64},
+    } {
+        if err := tc.Validate(); err == nil {
+            t.Fatalf("accepted %s", tc.String())
+        }
+    }
+}
+
+// A player who doesn't move loses on time
+func TestTimeout(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer creator.Close()
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points, TimeControl: &TimeControl{Mode: SuddenDeath, MainMillis: 200}})
+    reply, err := read(creator)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reply.Clock == nil || reply.Clock.Running != -1 {
+        t.Fatal("clock running before the join")
+    }
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer joiner.Close()
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: reply.Key})
+    joined, err := read(joiner)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if joined.Clock == nil || joined.Clock.Running != 0 {
+        t.Fatal("clock not started")
+    }
+    for _,conn := range []*websocket.Conn{creator, joiner} {
+        for {
+            reply, err = read(conn)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if reply.Action == "Timeout" {
+                break
+            }
+        }
+        if reply.Player != 0 || !reply.GameOver {
+            t.Fatalf("timeout %d", reply.Player)
+        }
+        // Players get the clock like spectators do
+        if reply.Clock == nil {
+            t.Fatal("timeout without clock")
+        }
+    }
+    game := store.Get(joined.Key)
+    game.Lock()
+    defer game.Unlock()
+    if game.Result != "Black loses on time" {
+        t.Fatal(game.Result)
+    }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "errors"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Codes of Error replies
+const (
+    // Unreadable message, unknown action or bad fields
+    ErrBadRequest = "BadRequest"
+    // No board with the name
+    ErrNoBoard = "NoBoard"
+    // The points don't fit the board, GeometryError says why, or the board
+    // file can't be built, e.g. it has too many lines
+    ErrBadBoard = "BadBoard"
+    // No game with the key
+    ErrNoGame = "NoGame"
+    // The connection has no seat in the game
+    ErrNotInGame = "NotInGame"
+    ErrGameOver = "GameOver"
+    ErrNotYourTurn = "NotYourTurn"
+    ErrIllegalMove = "IllegalMove"
+    // The action isn't possible now, e.g. joining a full game
+    ErrNotAllowed = "NotAllowed"
+    ErrBadToken = "BadToken"
+    // Register, Login and Profile failures
+    ErrAccount = "Account"
+)
+
+// An error with a code for the client
+type ProtocolError struct {
+    Code string
+    Message string
+}
+
+func (e *ProtocolError) Error() string {
+    return e.Message
+}
+
+func Fail(code string, message string) error {
+    return &ProtocolError{Code: code, Message: message}
+}
+
+// Error reply for a failed request
+// Errors without a code are BadRequest, board geometry errors are BadBoard
+func ErrorReply(req *Request, err error) Reply {
+    reply := Reply{Action: "Error", Id: req.Id, Request: req.Action, Key: req.Key, Code: ErrBadRequest, Error: err.Error()}
+    var perr *ProtocolError
+    var gerr *ai.GeometryError
+    if errors.As(err, &perr) {
+        reply.Code = perr.Code
+    } else if errors.As(err, &gerr) {
+        reply.Code = ErrBadBoard
+        reply.GeometryError = gerr
+    }
+    return reply
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+func TestErrorReplies(t *testing.T) {
+    url := testServer(t)
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    expect := func(id int, action string, code string) Reply {
+        t.Helper()
+        reply, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reply.Action != "Error" || reply.Id != id || reply.Request != action || reply.Code != code || reply.Error == "" {
+            t.Fatalf("expected %s for %s %d, got %s %s %d %q", code, action, id, reply.Action, reply.Code, reply.Id, reply.Error)
+        }
+        return reply
+    }
+
+    conn.WriteMessage(1, []byte("{not json"))
+    expect(0, "", ErrBadRequest)
+    conn.WriteJSON(Request{Id: 1, Action: "Dance"})
+    expect(1, "Dance", ErrBadRequest)
+    // Unknown keys used to panic
+    for i,action := range []string{"Move", "Concede", "Chat", "Undo", "JoinGame", "Watch", "Record"} {
+        conn.WriteJSON(Request{Id: 10+i, Action: action, Key: 99})
+        if reply := expect(10+i, action, ErrNoGame); reply.Key != 99 {
+            t.Fatal("key not echoed")
+        }
+    }
+    conn.WriteJSON(Request{Id: 2, Action: "LoadBoard", BoardName: "../server/server.go"})
+    expect(2, "LoadBoard", ErrNoBoard)
+    conn.WriteJSON(Request{Id: 3, Action: "ListBoards"})
+    if reply, err := read(conn); err != nil || reply.Action != "ListBoards" || reply.Id != 3 {
+        t.Fatal("id not echoed", reply.Id, err)
+    }
+
+    points := startPoints(t)
+    bad := make([]ai.Point, len(points))
+    copy(bad, points)
+    bad[0].X += 1000
+    conn.WriteJSON(Request{Id: 4, Action: "NewGame", BoardName: testBoard, Points: bad})
+    if reply := expect(4, "NewGame", ErrBadBoard); reply.GeometryError == nil {
+        t.Fatal("no geometry error")
+    }
+    conn.WriteJSON(Request{Id: 5, Action: "NewGame", BoardName: testBoard, Points: points})
+    start, err := read(conn)
+    if err != nil || start.Action != "NewGame" || start.Id != 5 {
+        t.Fatal(start.Action, err)
+    }
+    // No opponent yet, the first illegal point is any that isn't a legal move
+    illegal := 0
+    for ai.Includes(start.LegalMoves, illegal) {
+        illegal++
+    }
+    conn.WriteJSON(Request{Id: 6, Action: "Move", Key: start.Key, Move: illegal})
+    expect(6, "Move", ErrIllegalMove)
+    conn.WriteJSON(Request{Id: 7, Action: "Move", Key: start.Key, Move: -5})
+    expect(7, "Move", ErrIllegalMove)
+    conn.WriteJSON(Request{Id: 8, Action: "Move", Key: start.Key, Move: start.LegalMoves[0]})
+    if reply, err := read(conn); err != nil || reply.Action != "Move" {
+        t.Fatal(reply.Action, err)
+    }
+    conn.WriteJSON(Request{Id: 9, Action: "Move", Key: start.Key, Move: start.LegalMoves[0]})
+    expect(9, "Move", ErrNotYourTurn)
+    conn.WriteJSON(Request{Id: 20, Action: "Undo", Key: start.Key})
+    expect(20, "Undo", ErrNotAllowed)
+    conn.WriteJSON(Request{Id: 21, Action: "Rejoin", Key: start.Key, Token: "nope"})
+    expect(21, "Rejoin", ErrBadToken)
+    conn.WriteJSON(Request{Id: 22, Action: "Concede", Key: start.Key})
+    if reply, err := read(conn); err != nil || reply.Action != "Concede" {
+        t.Fatal(reply.Action, err)
+    }
+    conn.WriteJSON(Request{Id: 23, Action: "Concede", Key: start.Key})
+    expect(23, "Concede", ErrGameOver)
+}
+
+// Replies to the requester echo its Id, those to the other seat don't
+func TestIdEcho(t *testing.T) {
+    url := testServer(t)
+    conns := make([]*websocket.Conn, 4)
+    for i := range conns {
+        conn, err := dial(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conns[i] = conn
+    }
+    expect := func(conn *websocket.Conn, action string, id int) Reply {
+        t.Helper()
+        reply, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reply.Action != action || reply.Id != id {
+            t.Fatalf("expected %s %d, got %s %d", action, id, reply.Action, reply.Id)
+        }
+        return reply
+    }
+    conns[0].WriteJSON(Request{Id: 1, Action: "NewGame", BoardName: testBoard, Points: startPoints(t)})
+    key := expect(conns[0], "NewGame", 1).Key
+    conns[1].WriteJSON(Request{Id: 2, Action: "JoinGame", Key: key})
+    token := expect(conns[1], "JoinGame", 2).Token
+    conns[2].WriteJSON(Request{Id: 3, Action: "Rejoin", Key: key, Token: token})
+    expect(conns[2], "Rejoin", 3)
+    expect(conns[0], "Reconnect", 0)
+    conns[3].WriteJSON(Request{Id: 4, Action: "Watch", Key: key})
+    expect(conns[3], "Watch", 4)
+}
+
+// Boards with too many lines are refused before any game starts
+func TestTooManyLines(t *testing.T) {
+    points := startPoints(t)
+    maxLines := ai.DefaultLineOptions.MaxLines
+    ai.DefaultLineOptions.MaxLines = 10
+    defer func() {
+        ai.DefaultLineOptions.MaxLines = maxLines
+    }()
+    req := Request{Action: "NewGame", BoardName: testBoard, Points: points}
+    _, _, err := BoardFromRequest(req)
+    if err == nil {
+        t.Fatal("board with too many lines built")
+    }
+    if reply := ErrorReply(&req, err); reply.Code != ErrBadBoard {
+        t.Fatal(reply.Code, err)
+    }
+}
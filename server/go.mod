@@ -2,7 +2,6 @@ module github.com/aorliche/web-nongrid-othello
 
 go 1.20
 
-require (
-	github.com/gorilla/websocket v1.5.1 // indirect
-	golang.org/x/net v0.17.0 // indirect
-)
+require github.com/gorilla/websocket v1.5.1
+
+require golang.org/x/net v0.17.0 // indirect
@@ -0,0 +1,124 @@
+package main
+
+import (
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// One move of a game, Move is the point id or ai.Pass
+// Flips are the stones the move turned over
+type LoggedMove struct {
+    Ply int
+    Player int
+    Move int
+    Flips []int
+}
+
+// Methods below need the game locked
+
+// Index in the log of the last move a player placed a stone, -1 if none
+func (game *Game) LastMoveOf(player int) int {
+    for i := len(game.Log)-1; i >= 0; i-- {
+        if game.Log[i].Player == player && game.Log[i].Move != ai.Pass {
+            return i
+        }
+    }
+    return -1
+}
+
+// Back to the position before the nth move, replayed from the start
+func (game *Game) Rewind(n int) {
+    moves := game.Log[:n]
+    board := game.Board.Clone()
+    copy(board.Points, game.Start)
+    board.Turn = 0
+    board.Rehash()
+    game.Board = board
+    game.Log = make([]LoggedMove, 0, n)
+    for _,entry := range moves {
+        game.Play(entry.Move)
+    }
+    game.Touch()
+    game.Save()
+}
+
+// Sends the board after a rewind to everyone
+func (game *Game) SendUndo(player int) {
+    board := game.Board
+    for i := range game.Conns {
+        game.Send(i, Reply{Action: "Undo", Player: player, Points: board.Points, LegalMoves: MovesFor(board, i), Turn: board.Turn, Log: game.Log})
+    }
+    game.Spectate(Reply{Action: "Undo", Player: player, Points: board.Points, LegalMoves: make([]int, 0), Turn: board.Turn, Log: game.Log})
+}
+
+// Handlers below lock the game themselves
+
+// Takes back the human's last move and any AI replies
+// A search in progress is thrown away by GameLoop
+func Undo(game *Game, client *Client) error {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    if !game.AIGame {
+        return Fail(ErrNotAllowed, "undo is for AI games, ask for a takeback")
+    }
+    n := game.LastMoveOf(player)
+    if n == -1 {
+        return Fail(ErrNotAllowed, "nothing to undo")
+    }
+    game.Rewind(n)
+    game.RunClock()
+    game.SendUndo(player)
+    game.Wake()
+    return nil
+}
+
+// Asks the opponent in a two player game to take back the last move of player
+func Takeback(game *Game, client *Client) error {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    if game.AIGame || game.LastMoveOf(player) == -1 {
+        return Fail(ErrNotAllowed, "no move to take back")
+    }
+    game.Takeback = player
+    game.Send(1-player, Reply{Action: "Takeback", Player: player})
+    return nil
+}
+
+// The opponent of the player who asked accepts or declines
+func AnswerTakeback(game *Game, client *Client, accept bool) error {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    asker := game.Takeback
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    if asker == -1 || asker == player {
+        return Fail(ErrNotAllowed, "no takeback to answer")
+    }
+    game.Takeback = -1
+    if !accept {
+        game.Send(asker, Reply{Action: "DeclineTakeback", Player: player})
+        return nil
+    }
+    game.Rewind(game.LastMoveOf(asker))
+    game.RunClock()
+    game.SendUndo(asker)
+    return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestUndo(t *testing.T) {
+    url := testServer(t)
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: startPoints(t)})
+    start, err := read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := start.Key
+    conn.WriteJSON(Request{Action: "Move", Key: key, Move: start.LegalMoves[0]})
+    for {
+        reply, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reply.Player == 1 {
+            break
+        }
+    }
+    conn.WriteJSON(Request{Action: "Undo", Key: key})
+    undo, err := read(conn)
+    if err != nil || undo.Action != "Undo" {
+        t.Fatal("expected undo", undo.Action, err)
+    }
+    if undo.Turn != 0 || len(undo.Log) != 0 || len(undo.LegalMoves) != len(start.LegalMoves) {
+        t.Fatalf("undo to turn %d with %d moves logged", undo.Turn, len(undo.Log))
+    }
+    for i,p := range undo.Points {
+        if p.Player != start.Points[i].Player {
+            t.Fatalf("point %d not restored", i)
+        }
+    }
+    // The AI keeps playing after an undo
+    conn.WriteJSON(Request{Action: "Move", Key: key, Move: undo.LegalMoves[1]})
+    for {
+        reply, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if reply.Player == 1 {
+            break
+        }
+    }
+    game := store.Get(key)
+    game.Lock()
+    defer game.Unlock()
+    if len(game.Log) != 2 || game.Log[0].Move != undo.LegalMoves[1] || len(game.Log[0].Flips) == 0 {
+        t.Fatalf("log after undo %v", game.Log)
+    }
+}
+
+func TestTakeback(t *testing.T) {
+    url := testServer(t)
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer creator.Close()
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: startPoints(t)})
+    start, err := read(creator)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := start.Key
+    move := start.LegalMoves[0]
+    creator.WriteJSON(Request{Action: "Move", Key: key, Move: move})
+    read(creator)
+
+    // Late joiners get the log
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer joiner.Close()
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+    joined, err := read(joiner)
+    if err != nil || len(joined.Log) != 1 || joined.Log[0].Move != move || joined.Log[0].Player != 0 || len(joined.Log[0].Flips) == 0 {
+        t.Fatalf("join log %v %v", joined.Log, err)
+    }
+
+    creator.WriteJSON(Request{Action: "Takeback", Key: key})
+    if ask, err := read(joiner); err != nil || ask.Action != "Takeback" || ask.Player != 0 {
+        t.Fatal("expected takeback", ask.Action, err)
+    }
+    joiner.WriteJSON(Request{Action: "DeclineTakeback", Key: key})
+    if no, err := read(creator); err != nil || no.Action != "DeclineTakeback" {
+        t.Fatal("expected decline", no.Action, err)
+    }
+    // Only the opponent of the asker can accept
+    creator.WriteJSON(Request{Action: "Takeback", Key: key})
+    read(joiner)
+    creator.WriteJSON(Request{Action: "AcceptTakeback", Key: key})
+    joiner.WriteJSON(Request{Action: "AcceptTakeback", Key: key})
+    for _,conn := range []*websocket.Conn{creator, joiner} {
+        undo, err := read(conn)
+        if err != nil || undo.Action != "Undo" || undo.Turn != 0 || len(undo.Log) != 0 {
+            t.Fatal("expected undo", undo.Action, undo.Turn, err)
+        }
+        if (conn == creator) != (len(undo.LegalMoves) > 0) {
+            t.Fatal("legal moves after undo")
+        }
+    }
+}
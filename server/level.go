@@ -0,0 +1,57 @@
+package main
+
+import (
+    "errors"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Strength of a server AI
+type Level struct {
+    Depth int
+    TimeMillis int
+    // "discs" or "positional"
+    Eval string
+    // Empties at which alpha-beta solves the game exactly, 0 never
+    Endgame int
+    // Chance of a random move instead of the engine's
+    Blunder float64
+}
+
+// The level of AI games that don't name one
+const DefaultLevel = "hard"
+
+var Levels = map[string]Level{
+    "beginner": {Depth: 2, TimeMillis: 200, Eval: "discs", Blunder: 0.3},
+    "easy": {Depth: 3, TimeMillis: 500, Eval: "discs", Blunder: 0.1},
+    "medium": {Depth: 5, TimeMillis: 1000, Eval: "positional", Endgame: 8, Blunder: 0.03},
+    "hard": {Depth: 10, TimeMillis: 2000, Eval: "positional", Endgame: ai.EndgameEmpties},
+    "expert": {Depth: 20, TimeMillis: 5000, Eval: "positional", Endgame: 16},
+}
+
+var evaluators = map[string]ai.Evaluator{
+    "discs": ai.DiscCount{},
+    "positional": ai.DefaultEvaluator,
+}
+
+func GetLevel(name string) (Level, error) {
+    if name == "" {
+        name = DefaultLevel
+    }
+    level, ok := Levels[name]
+    if !ok {
+        return level, errors.New("unknown level " + name)
+    }
+    return level, nil
+}
+
+// Which seat the AI takes, white if empty
+func AISeat(color string) (int, error) {
+    switch color {
+    case "black":
+        return 0, nil
+    case "", "white":
+        return 1, nil
+    }
+    return -1, errors.New("unknown color " + color)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "testing"
+)
+
+// The AI moves first when it plays black
+func TestAIPlaysBlack(t *testing.T) {
+    url := testServer(t)
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    points := startPoints(t)
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: points, Level: "grandmaster"})
+    if reply, err := read(conn); err != nil || reply.Action != "Error" || reply.Request != "NewGame" || reply.Error == "" {
+        t.Fatal("unknown level accepted", err)
+    }
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: points, Level: "beginner", AIColor: "black"})
+    start, err := read(conn)
+    if err != nil || start.Action != "NewGame" {
+        t.Fatal(start.Error, err)
+    }
+    if start.Player != 1 || len(start.LegalMoves) != 0 {
+        t.Fatalf("human plays %d with moves %v", start.Player, start.LegalMoves)
+    }
+    for turn := 0; turn < 3; turn++ {
+        reply, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // The server passes for us
+        if reply.Pass && reply.Player == 1 {
+            continue
+        }
+        if reply.Player != 0 || reply.Action != "Move" {
+            t.Fatalf("expected the AI's move, got %s by %d", reply.Action, reply.Player)
+        }
+        if reply.GameOver {
+            break
+        }
+        if len(reply.LegalMoves) == 0 {
+            continue
+        }
+        conn.WriteJSON(Request{Action: "Move", Key: start.Key, Move: reply.LegalMoves[0]})
+        if mine, err := read(conn); err != nil || mine.Player != 1 {
+            t.Fatal("own move", mine.Player, err)
+        }
+    }
+    game := store.Get(start.Key)
+    game.Lock()
+    defer game.Unlock()
+    if game.AISeat != 0 || game.Log[0].Player != 0 || game.Export().Tag("Black") != "AI alphabeta beginner" {
+        t.Fatal("AI didn't play black")
+    }
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Everything needed to rebuild a game after a restart
+// The plan may change on disk so the points and lines are kept too
+type GameRecord struct {
+    Key int
+    BoardName string
+    BoardPlan string
+    Points []ai.Point
+    // Missing in older records, see GameFromRecord
+    Neighbors [][]int
+    Lines []ai.Line
+    Moves []int
+    AIGame bool
+    // The AI plays white unless set
+    AIBlack bool
+    Engine string
+    Level string
+    // Seats that were taken
+    Players []bool
+    // Seat tokens so players can Rejoin after a restart
+    Tokens []string
+    // Accounts of the seats
+    Names []string
+    GameOver bool
+    Result string
+    Created time.Time
+    Clock *Clock
+    // Missing in older records, see GameFromRecord
+    Fingerprint string
+}
+
+// Backends for game storage
+// Save is called with the game locked after every change
+type Persister interface {
+    Save(rec *GameRecord) error
+    Load() ([]*GameRecord, error)
+}
+
+// One JSON file per game in Dir
+type FilePersister struct {
+    Dir string
+}
+
+func NewFilePersister(dir string) (*FilePersister, error) {
+    err := os.MkdirAll(dir, 0755)
+    if err != nil {
+        return nil, err
+    }
+    return &FilePersister{Dir: dir}, nil
+}
+
+// Writes a temp file and renames it so a crash never leaves half a record
+func (p *FilePersister) Save(rec *GameRecord) error {
+    jsn, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    name := filepath.Join(p.Dir, fmt.Sprintf("%d.json", rec.Key))
+    err = os.WriteFile(name + ".tmp", jsn, 0644)
+    if err != nil {
+        return err
+    }
+    return os.Rename(name + ".tmp", name)
+}
+
+// Unreadable files are logged and skipped
+func (p *FilePersister) Load() ([]*GameRecord, error) {
+    files, err := os.ReadDir(p.Dir)
+    if err != nil {
+        return nil, err
+    }
+    recs := make([]*GameRecord, 0)
+    for _,f := range files {
+        if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+            continue
+        }
+        dat, err := os.ReadFile(filepath.Join(p.Dir, f.Name()))
+        if err != nil {
+            log.Println(err)
+            continue
+        }
+        var rec GameRecord
+        err = json.Unmarshal(dat, &rec)
+        if err != nil {
+            log.Println(f.Name(), err)
+            continue
+        }
+        recs = append(recs, &rec)
+    }
+    return recs, nil
+}
+
+// Needs the game locked
+func (game *Game) Record() *GameRecord {
+    moves := make([]int, len(game.Log))
+    for i,entry := range game.Log {
+        moves[i] = entry.Move
+    }
+    players := make([]bool, len(game.Conns))
+    for i,c := range game.Conns {
+        players[i] = c != nil
+    }
+    return &GameRecord{
+        Key: game.Key,
+        BoardName: game.BoardName,
+        BoardPlan: game.BoardPlan,
+        Points: game.Start,
+        Neighbors: game.Board.Neighbors,
+        Lines: game.Board.Lines,
+        Moves: moves,
+        AIGame: game.AIGame,
+        AIBlack: game.AISeat == 0,
+        Engine: game.Engine,
+        Level: game.Level,
+        Players: players,
+        Tokens: game.Tokens,
+        Names: game.Names,
+        GameOver: game.GameOver,
+        Result: game.Result,
+        Created: game.Created,
+        Clock: game.Clock,
+        Fingerprint: game.Fingerprint,
+    }
+}
+
+// Needs the game locked, errors are only logged
+func (game *Game) Save() {
+    if game.persist == nil {
+        return
+    }
+    err := game.persist.Save(game.Record())
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+// Replays the moves of a record, seats with a token are kept for Rejoin
+func GameFromRecord(rec *GameRecord) (*Game, error) {
+    if len(rec.Points) == 0 || len(rec.Points) > ai.MaxPoints {
+        return nil, fmt.Errorf("game %d: %d points", rec.Key, len(rec.Points))
+    }
+    for _,line := range rec.Lines {
+        for _,id := range line.Ids {
+            if id < 0 || id >= len(rec.Points) {
+                return nil, fmt.Errorf("game %d: line point %d out of range", rec.Key, id)
+            }
+        }
+    }
+    if rec.Neighbors != nil && len(rec.Neighbors) != len(rec.Points) {
+        return nil, fmt.Errorf("game %d: neighbors of %d points", rec.Key, len(rec.Neighbors))
+    }
+    for _,ns := range rec.Neighbors {
+        for _,id := range ns {
+            if id < 0 || id >= len(rec.Points) {
+                return nil, fmt.Errorf("game %d: neighbor %d out of range", rec.Key, id)
+            }
+        }
+    }
+    points := make([]ai.Point, len(rec.Points))
+    copy(points, rec.Points)
+    board := ai.NewBoard(points, rec.Lines)
+    board.Neighbors = rec.Neighbors
+    // Older records only have the plan's neighbors
+    if board.Neighbors == nil && rec.BoardPlan != "" {
+        if planPoints, ns, err := ai.PlanToPoints(rec.BoardPlan); err == nil && len(planPoints) == len(points) {
+            board.Neighbors = ns
+        }
+    }
+    aiSeat := -1
+    if rec.AIGame {
+        aiSeat = 1
+        if rec.AIBlack {
+            aiSeat = 0
+        }
+    }
+    game := NewGame(rec.BoardName, rec.BoardPlan, board, nil, aiSeat)
+    game.Key = rec.Key
+    game.Engine = rec.Engine
+    game.Level = rec.Level
+    game.Result = rec.Result
+    game.Fingerprint = rec.Fingerprint
+    if game.Fingerprint == "" {
+        game.Fingerprint = board.Fingerprint()
+    }
+    // Clocks wait for the players to come back, see RestoreGames
+    game.Clock = rec.Clock
+    if game.Clock != nil {
+        game.Clock.Running = -1
+    }
+    if !rec.Created.IsZero() {
+        game.Created = rec.Created
+    }
+    // AI games used to have one seat
+    copy(game.Tokens, rec.Tokens)
+    copy(game.Names, rec.Names)
+    for i,move := range rec.Moves {
+        if !board.MoveIsLegal(move) {
+            return nil, fmt.Errorf("game %d: illegal move %d at %d", rec.Key, move, i)
+        }
+        game.Play(move)
+    }
+    game.GameOver = rec.GameOver || board.GameOver()
+    return game, nil
+}
+
+// Puts unfinished games from Persist back in the store and restarts their AIs.
+// Keys of every record are reserved so new games don't overwrite old files.
+func (store *GameStore) RestoreGames() error {
+    recs, err := store.Persist.Load()
+    if err != nil {
+        return err
+    }
+    for _,rec := range recs {
+        store.Reserve(rec.Key)
+    }
+    for _,rec := range recs {
+        if rec.GameOver {
+            continue
+        }
+        game, err := GameFromRecord(rec)
+        if err != nil {
+            log.Println(err)
+            continue
+        }
+        if game.GameOver {
+            continue
+        }
+        var engine ai.Engine
+        if game.AIGame {
+            engine, err = MakeEngine(game.Engine, game.Level)
+            if err != nil {
+                log.Println(err)
+                continue
+            }
+        }
+        store.Restore(game)
+        log.Println("restored game", game.Key)
+        // Nobody is connected yet, Rejoin starts the clock
+        game.Lock()
+        game.held = game.Clock != nil && (game.AIGame || game.FreeSeat() == -1)
+        game.Unlock()
+        if engine != nil {
+            go GameLoop(game, engine)
+        }
+    }
+    return nil
+}
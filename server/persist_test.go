@@ -0,0 +1,229 @@
+package main
+
+import (
+    "math/rand"
+    "testing"
+    "time"
+)
+
+// Games survive a restart and players can Rejoin with their tokens
+func TestRestoreGames(t *testing.T) {
+    url := testServer(t)
+    p, err := NewFilePersister(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    store.Persist = p
+    points := startPoints(t)
+    rng := rand.New(rand.NewSource(1))
+
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+    reply, err := read(creator)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := reply.Key
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+    joined, err := read(joiner)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tokens := []string{reply.Token, joined.Token}
+    for i := 0; i < 6; i++ {
+        creator.WriteJSON(Request{Action: "Move", Key: key, Move: reply.LegalMoves[rng.Intn(len(reply.LegalMoves))]})
+        read(creator)
+        opp, err := read(joiner)
+        if err != nil {
+            t.Fatal(err)
+        }
+        joiner.WriteJSON(Request{Action: "Move", Key: key, Move: opp.LegalMoves[rng.Intn(len(opp.LegalMoves))]})
+        read(joiner)
+        reply, err = read(creator)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    old := store.Get(key)
+    old.Lock()
+    want := old.Board.Clone()
+    moves := len(old.Log)
+    // The index built by testServer has the board
+    fp := old.Fingerprint
+    if known, err := boardIndex.Lookup(testBoard); err != nil || fp != known {
+        t.Fatalf("fingerprint %q, index has %q", fp, known)
+    }
+    // Like a restart, the closing sockets would concede
+    old.persist = nil
+    old.Unlock()
+
+    store = NewGameStore()
+    store.Persist = p
+    if err := store.RestoreGames(); err != nil {
+        t.Fatal(err)
+    }
+    creator.Close()
+    joiner.Close()
+    game := store.Get(key)
+    if game == nil {
+        t.Fatal("game not restored")
+    }
+    if len(game.Log) != moves || game.Board.Turn != want.Turn || game.Board.Hash() != want.Hash() {
+        t.Fatal("restored board differs")
+    }
+    if game.Fingerprint != fp || game.Board.Fingerprint() != fp {
+        t.Fatal("restored fingerprint differs")
+    }
+    if open := store.Open(); len(open) != 0 {
+        t.Fatalf("open games %v", open)
+    }
+
+    for seat := 1; seat >= 0; seat-- {
+        conn, err := dial(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.WriteJSON(Request{Action: "Rejoin", Key: key, Token: tokens[seat]})
+        state, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if state.Action != "Rejoin" || state.Player != seat || state.Turn != want.Turn {
+            t.Fatalf("rejoined %v as %d", state.Action, state.Player)
+        }
+    }
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+    reply, err = read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reply.Key <= key {
+        t.Fatalf("new key %d reuses restored key %d", reply.Key, key)
+    }
+}
+
+// Finished games are kept but not restored
+func TestRestoreSkipsFinished(t *testing.T) {
+    url := testServer(t)
+    p, err := NewFilePersister(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    store.Persist = p
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: startPoints(t)})
+    reply, err := read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conn.WriteJSON(Request{Action: "Concede", Key: reply.Key})
+    if reply, err = read(conn); err != nil || reply.Action != "Concede" {
+        t.Fatal(reply.Action, err)
+    }
+    conn.Close()
+    time.Sleep(50 * time.Millisecond)
+    recs, err := p.Load()
+    if err != nil || len(recs) != 1 || !recs[0].GameOver {
+        t.Fatal("finished game not saved", err)
+    }
+    store = NewGameStore()
+    store.Persist = p
+    store.RestoreGames()
+    if store.Len() != 0 {
+        t.Fatal("finished game restored")
+    }
+    // Its key is still taken
+    conn, err = dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: startPoints(t)})
+    next, err := read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if next.Key <= recs[0].Key {
+        t.Fatalf("new key %d reuses finished key %d", next.Key, recs[0].Key)
+    }
+}
+
+// Restored clocks wait for the player to move, not for the other one
+func TestRestoredClockWaits(t *testing.T) {
+    url := testServer(t)
+    p, err := NewFilePersister(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    store.Persist = p
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: startPoints(t), TimeControl: &TimeControl{Mode: SuddenDeath, MainMillis: 100}})
+    reply, err := read(creator)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := reply.Key
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+    joined, err := read(joiner)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tokens := []string{reply.Token, joined.Token}
+    old := store.Get(key)
+    old.Lock()
+    old.persist = nil
+    old.Clock.Halt(time.Now())
+    old.Unlock()
+
+    store = NewGameStore()
+    store.Persist = p
+    if err := store.RestoreGames(); err != nil {
+        t.Fatal(err)
+    }
+    creator.Close()
+    joiner.Close()
+    // Longer than the main time, black would have lost by now
+    time.Sleep(200 * time.Millisecond)
+    for seat := 1; seat >= 0; seat-- {
+        conn, err := dial(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.WriteJSON(Request{Action: "Rejoin", Key: key, Token: tokens[seat]})
+        state, err := read(conn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if state.Action != "Rejoin" || state.GameOver || state.Clock == nil {
+            t.Fatalf("rejoined %v, game over %v", state.Action, state.GameOver)
+        }
+        // Black is to move, white coming back first doesn't start the clock
+        if running := []int{0, -1}[seat]; state.Clock.Running != running {
+            t.Fatalf("clock of %d running after seat %d rejoined", state.Clock.Running, seat)
+        }
+    }
+}
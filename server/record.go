@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+var colorNames = []string{"Black", "White"}
+
+// Methods below need the game locked
+
+// Ends the game with player conceding
+func (game *Game) Resign(player int) {
+    if !game.GameOver {
+        game.Result = colorNames[player] + " resigns"
+        game.Loser = player
+    }
+    game.Stop()
+}
+
+// Record with the score, or who resigned, as Result
+func (game *Game) Export() *ai.Record {
+    start := &ai.Board{Points: game.Start, Lines: game.Board.Lines, Neighbors: game.Board.Neighbors}
+    moves := make([]int, len(game.Log))
+    for i,entry := range game.Log {
+        moves[i] = entry.Move
+    }
+    rec := ai.NewRecord(start, moves)
+    rec.SetTag("Board", game.BoardName)
+    rec.SetTag("Fingerprint", game.Fingerprint)
+    rec.SetTag("Game", strconv.Itoa(game.Key))
+    rec.SetTag("Date", game.Created.Format("2006-01-02"))
+    for i,color := range colorNames {
+        name := "Player"
+        if i == game.AISeat {
+            engine, level := game.Engine, game.Level
+            if engine == "" {
+                engine = "alphabeta"
+            }
+            if level == "" {
+                level = DefaultLevel
+            }
+            name = "AI " + engine + " " + level
+        } else if game.Names[i] != "" {
+            name = game.Names[i]
+        }
+        rec.SetTag(color, name)
+    }
+    if game.Clock != nil {
+        rec.SetTag("TimeControl", game.Clock.Control.String())
+    }
+    result := game.Result
+    if result == "" {
+        result = ai.ScoreResult(game.Board)
+    }
+    rec.SetTag("Result", result)
+    return rec
+}
+
+func RecordText(game *Game) string {
+    game.Lock()
+    defer game.Unlock()
+    return game.Export().String()
+}
+
+// GET /record?key=3 downloads the record of a game
+func DownloadRecord(w http.ResponseWriter, r *http.Request) {
+    key, err := strconv.Atoi(r.URL.Query().Get("key"))
+    if err != nil {
+        http.Error(w, "bad key", http.StatusBadRequest)
+        return
+    }
+    game := store.Get(key)
+    if game == nil {
+        http.Error(w, "game not found", http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"game-%d.txt\"", key))
+    _, err = w.Write([]byte(RecordText(game)))
+    if err != nil {
+        log.Println(err)
+    }
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+func TestRecord(t *testing.T) {
+    url := testServer(t)
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: startPoints(t)})
+    reply, err := read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := reply.Key
+    conn.WriteJSON(Request{Action: "Move", Key: key, Move: reply.LegalMoves[0]})
+    for reply.Player != 1 {
+        if reply, err = read(conn); err != nil {
+            t.Fatal(err)
+        }
+    }
+    conn.WriteJSON(Request{Action: "Concede", Key: key})
+    read(conn)
+    conn.WriteJSON(Request{Action: "Record", Key: key})
+    reply, err = read(conn)
+    if err != nil || reply.Action != "Record" {
+        t.Fatal("expected record", reply.Action, err)
+    }
+    rec, err := ai.DecodeRecord(strings.NewReader(reply.Text))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rec.Tag("Board") != testBoard || rec.Tag("Result") != "Black resigns" || rec.Tag("White") != "AI alphabeta hard" {
+        t.Errorf("tags %v", rec.Tags)
+    }
+    board, err := rec.Replay()
+    if err != nil {
+        t.Fatal(err)
+    }
+    game := store.Get(key)
+    game.Lock()
+    if board.Hash() != game.Board.Hash() || len(rec.Moves) != len(game.Log) {
+        t.Error("replay differs from game")
+    }
+    if rec.Tag("Fingerprint") != game.Fingerprint || board.Fingerprint() != game.Fingerprint {
+        t.Errorf("fingerprint %v, game has %v", rec.Tag("Fingerprint"), game.Fingerprint)
+    }
+    game.Unlock()
+
+    w := httptest.NewRecorder()
+    DownloadRecord(w, httptest.NewRequest("GET", "/record?key=" + strconv.Itoa(key), nil))
+    if w.Code != 200 || w.Body.String() != reply.Text {
+        t.Errorf("download got %d", w.Code)
+    }
+    w = httptest.NewRecorder()
+    DownloadRecord(w, httptest.NewRequest("GET", "/record?key=99", nil))
+    if w.Code != 404 {
+        t.Errorf("got %d, expect 404", w.Code)
+    }
+}
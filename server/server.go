@@ -2,38 +2,43 @@ package main
 
 import (
     "encoding/json"
-    //"fmt"
+    "flag"
+    "fmt"
     "log"
     "net/http"
     "os"
+    "strings"
 
     "github.com/gorilla/websocket"
     ai "github.com/aorliche/web-nongrid-othello/ai"
 )
 
-type Game struct {
-    Key int
-    BoardName string
-    BoardPlan string
-    Board *ai.Board
-    Conns []*websocket.Conn
-    RecvChan chan bool
-    AIGame bool
-    GameOver bool
-}
-
 // Actions:
-// ListBoards, LoadBoard, ListGames, NewGame, JoinGame, Move, Concede, Chat
+// ListBoards, LoadBoard, ListGames, NewGame, JoinGame, Rejoin, Watch, Move, Undo,
+// Takeback, AcceptTakeback, DeclineTakeback, Record, Register, Login, Leaderboard,
+// Profile, Concede, Chat
 // ListBoards: [none]
 // LoadBoard: BoardName
 // ListGames: [none]
-// NewGame: AIGame, BoardName, Points, Neighbors
+// NewGame: AIGame, BoardName, Points, Neighbors, TimeControl, and for AI games
+//   Engine ("alphabeta" or "mcts"), Level (see Levels) and AIColor ("black" or "white")
 // JoinGame: Key
-// Move: Key, Move
+// Rejoin: Key, Token
+// Watch: Key
+// Move: Key, Move (ai.Pass only when there are no other moves)
+// Undo: Key (AI games, takes back your last move)
+// Takeback, AcceptTakeback, DeclineTakeback: Key (two player games)
+// Record: Key
+// Register: Name
+// Login: Name, Secret
+// Leaderboard: BoardName (empty for overall ratings)
+// Profile: Name
 // Concede: Key
 // Chat: Key, Text
+// Any request may have an Id, echoed by its replies
 
 type Request struct {
+    Id int
     Key int
     Action string
     BoardName string
@@ -42,18 +47,47 @@ type Request struct {
     Move int
     Text string
     AIGame bool
+    Engine string
+    Level string
+    // AI games, white if empty
+    AIColor string
+    Token string
+    // Account name and secret
+    Name string
+    Secret string
+    // nil for no clock
+    TimeControl *TimeControl
 }
 
 // Actions:
-// ListBoards: BoardNames
+// ListBoards: BoardNames, boards that play the same as another are left out
 // LoadBoard: BoardPlan
-// ListGames: Keys
-// NewGame: Key, Points, LevalMoves, GameOver
-// JoinGame: Key, BoardPlan, Points, LegalMoves, GameOver
-// Move: Player, Points, LegalMoves, GameOver
+// ListGames: Keys (games with a free seat), Live (games to watch)
+// NewGame: Key, Player, Token, Points, LevalMoves, GameOver
+// JoinGame: Key, Player, Token, BoardPlan, Points, LegalMoves, Turn, Log, Names, GameOver
+// Rejoin: Key, Player, AIGame, BoardPlan, Points, LegalMoves, Turn, Log, Names, GameOver
+// Watch: Key, BoardPlan, Points, Turn, Log, Names, GameOver (then Move, Undo, Concede and Chat)
+// Undo: Player (whose move was taken back), Points, LegalMoves, Turn, Log
+// Takeback: Player (asks the opponent to accept)
+// DeclineTakeback: Player (who declined)
+// Record: Key, Text (the game record, see ai.Record)
+// Timeout: Player (who ran out of time), GameOver
+// Clock: Key, sent to the creator when the clock starts
+// Replies about a game with a time control also have Clock
+// Register: Name, Secret (to Login with later)
+// Login: Name, games started or joined after are rated
+// Leaderboard: Leaderboard (overall, or of BoardName if the request has it)
+// Profile: Profile of the Name in the request
+// Disconnect, Reconnect: Player (the opponent's connection dropped or came back)
+// Move: Player, Points, LegalMoves, GameOver, Pass (Player had no moves)
 // Concede: Player, GameOver
 // Chat: Player, Text
+// Error: Id, Request (the failed action), Key, Code (see errors.go), Error, GeometryError
+// Replies sent only to the requester echo its Id, not those sent to the other seats
 type Reply struct {
+    Id int
+    Request string
+    Code string
     Key int
     Player int
     Action string
@@ -61,24 +95,28 @@ type Reply struct {
     Points []ai.Point
     BoardNames []string
     Keys []int
+    Live []int
     LegalMoves []int
     GameOver bool
+    AIGame bool
+    Pass bool
+    Turn int
+    Token string
+    Log []LoggedMove
+    Clock *ClockState
+    // Accounts of the seats
+    Names []string
+    Name string
+    Secret string
+    Leaderboard []Standing
+    Profile *Profile
     Text string
+    Error string
+    GeometryError *ai.GeometryError
 }
 
-var games = make(map[int]*Game)
 var upgrader = websocket.Upgrader{} // Default options
 
-func NextGameIdx() int {
-    max := -1
-    for key := range games {
-        if key > max {
-            max = key
-        }
-    }
-    return max+1
-}
-
 func GetBoards() []string {
     boards := make([]string, 0)
     dir, err := os.Open("../boards")
@@ -100,266 +138,492 @@ func GetBoards() []string {
     return boards
 }
 
+// Names are file names in ../boards, paths are refused
 func GetBoard(name string) (string, error) {
+    if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+        return "", Fail(ErrNoBoard, fmt.Sprintf("no board %q", name))
+    }
     dat, err := os.ReadFile("../boards/" + name)
     if err != nil {
         log.Println(err)
-        return "", err
+        return "", Fail(ErrNoBoard, fmt.Sprintf("no board %q", name))
     }
     return string(dat), err
 }
 
-func GameLoop(game *Game, recvChan chan bool, sendChan chan bool) {
-    board := game.Board
-    for {
-        prev := board.Clone()
-        sendChan <- true
-        if board.GameOver() {
-            log.Println("game over")
-            log.Println(board.GetScores())
-            break
+// A board file may also declare its line model, see ai.BoardSpec
+func GetBoardSpec(name string) (*ai.BoardSpec, error) {
+    text, err := GetBoard(name)
+    if err != nil {
+        return nil, err
+    }
+    spec, err := ai.ParseBoard(text)
+    if err != nil {
+        log.Println(err)
+        return nil, Fail(ErrBadBoard, fmt.Sprintf("board %q: %v", name, err))
+    }
+    return spec, nil
+}
+
+// Geometry comes from the server-built plan, client points only supply the starting pieces
+func BoardFromRequest(req Request) (*ai.Board, string, error) {
+    spec, err := GetBoardSpec(req.BoardName)
+    if err != nil {
+        return nil, "", err
+    }
+    // Boards with too many lines are refused before they hold up the server
+    points, ns, lines, err := spec.Build(ai.DefaultLineOptions)
+    if err != nil {
+        return nil, "", Fail(ErrBadBoard, fmt.Sprintf("board %q: %v", req.BoardName, err))
+    }
+    // Older clients always send neighbors, check them if present
+    clientNs := req.Neighbors
+    if clientNs == nil {
+        clientNs = ns
+    }
+    err = ai.ValidateGeometry(req.Points, clientNs)
+    if err != nil {
+        return nil, "", err
+    }
+    err = ai.MatchGeometry(req.Points, points)
+    if err != nil {
+        return nil, "", err
+    }
+    for i := range points {
+        points[i].Player = req.Points[i].Player
+    }
+    board := ai.NewBoard(points, lines)
+    board.Neighbors = ns
+    return board, string(spec.Plan), nil
+}
+
+// Legal moves are only sent to the player whose turn it is
+func MovesFor(board *ai.Board, player int) []int {
+    if board.Turn % 2 != player {
+        return make([]int, 0)
+    }
+    return board.GetPossibleMoves()
+}
+
+// The point that was empty before and isn't after, or Pass
+func MovePlayed(prev *ai.Board, board *ai.Board) int {
+    for i := range board.Points {
+        if prev.Points[i].Player == -1 && board.Points[i].Player != -1 {
+            return i
         }
-        // Pinged by user or computer move
-        // Now board should have been updated
-        keepPlaying := <- recvChan
-        if !keepPlaying {
-            sendChan <- false
-            break
+    }
+    return ai.Pass
+}
+
+// Handlers below lock the game themselves, id is of the request they answer
+func StartGame(game *Game, engine ai.Engine, id int) {
+    game.Lock()
+    board := game.Board
+    // Black may have no moves on an unusual start
+    if !game.AIGame && board.MustPass() {
+        game.Play(ai.Pass)
+    }
+    game.GameOver = board.GameOver()
+    if game.GameOver {
+        game.Stop()
+    }
+    // Two player clocks start when the second player joins
+    if game.AIGame {
+        game.RunClock()
+    }
+    game.Save()
+    seat := game.CreatorSeat()
+    game.Send(seat, Reply{Id: id, Action: "NewGame", Key: game.Key, Player: seat, Token: game.Tokens[seat], Points: board.Points, LegalMoves: MovesFor(board, seat), GameOver: game.GameOver})
+    game.Unlock()
+    if engine != nil {
+        go GameLoop(game, engine)
+    }
+}
+
+// Takes the first free seat
+// AI games only have a free seat after a restart
+func JoinGame(game *Game, client *Client, name string, id int) error {
+    game.Lock()
+    defer game.Unlock()
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    if game.SeatOf(client) != -1 {
+        return Fail(ErrNotAllowed, "already in game")
+    }
+    seat := game.FreeSeat()
+    if seat == -1 {
+        return Fail(ErrNotAllowed, "game is full")
+    }
+    game.Conns[seat] = client
+    game.Tokens[seat] = NewToken()
+    game.Names[seat] = name
+    started := game.Clock != nil && game.Clock.Running == -1 && game.FreeSeat() == -1
+    if started {
+        game.RunClock()
+    }
+    game.Save()
+    board := game.Board
+    game.Send(seat, Reply{Id: id, Action: "JoinGame", Key: game.Key, Player: seat, Token: game.Tokens[seat], BoardPlan: game.BoardPlan, Points: board.Points, LegalMoves: MovesFor(board, seat), Turn: board.Turn, Log: game.Log, Names: game.Names, GameOver: game.GameOver})
+    if started {
+        game.Send(1-seat, Reply{Action: "Clock", Key: game.Key})
+    }
+    return nil
+}
+
+// Puts the client back in the seat of the token
+// A connection still in the seat is replaced
+func Rejoin(game *Game, client *Client, token string, id int) error {
+    game.Lock()
+    defer game.Unlock()
+    seat := game.SeatFor(token)
+    if seat == -1 {
+        return Fail(ErrBadToken, "no seat has the token")
+    }
+    game.Conns[seat] = client
+    if game.held {
+        game.RunClock()
+    }
+    game.Save()
+    board := game.Board
+    game.Send(seat, Reply{Id: id, Action: "Rejoin", Key: game.Key, Player: seat, AIGame: game.AIGame, BoardPlan: game.BoardPlan, Points: board.Points, LegalMoves: MovesFor(board, seat), Turn: board.Turn, Log: game.Log, Names: game.Names, GameOver: game.GameOver})
+    if !game.GameOver {
+        for i := range game.Conns {
+            if i != seat {
+                game.Send(i, Reply{Action: "Reconnect", Player: seat})
+            }
         }
-        player := prev.Turn % 2
-        moves := board.GetPossibleMoves()
-        game.GameOver = len(moves) == 0
-        reply := Reply{Action: "Move", Player: player, Points: board.Points, LegalMoves: moves, GameOver: game.GameOver}
-        jsn, _ := json.Marshal(reply)
-        err := game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
-        if err != nil {
-            log.Println(err)
-            continue
+    }
+    return nil
+}
+
+func MakeMove(game *Game, client *Client, move int) error {
+    game.Lock()
+    defer game.Unlock()
+    board := game.Board
+    player := game.SeatOf(client)
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    if board.Turn % 2 != player {
+        return Fail(ErrNotYourTurn, "not your turn")
+    }
+    // Check if the move is legal and make the move
+    if !board.MoveIsLegal(move) {
+        return Fail(ErrIllegalMove, fmt.Sprintf("illegal move %d", move))
+    }
+    if !game.PressClock(player) {
+        return Fail(ErrGameOver, "out of time")
+    }
+    game.Play(move)
+    game.Touch()
+    // The opponent passes right away if they can't move
+    // The AI passes by itself
+    oppPassed := false
+    if !game.AIGame && board.MustPass() {
+        game.Play(ai.Pass)
+        oppPassed = true
+    }
+    game.GameOver = board.GameOver()
+    if game.GameOver {
+        game.Stop()
+    } else {
+        game.RunClock()
+    }
+    game.Save()
+    reply := Reply{Action: "Move", Player: player, Points: board.Points, LegalMoves: make([]int, 0), GameOver: game.GameOver, Pass: move == ai.Pass}
+    game.Send(player, reply)
+    game.Spectate(reply)
+    if game.AIGame {
+        game.Wake()
+        return nil
+    }
+    game.Send(1-player, Reply{Action: "Move", Player: player, Points: board.Points, LegalMoves: MovesFor(board, 1-player), GameOver: game.GameOver, Pass: move == ai.Pass})
+    if oppPassed {
+        for i := range game.Conns {
+            game.Send(i, Reply{Action: "Move", Player: 1-player, Points: board.Points, LegalMoves: MovesFor(board, i), GameOver: game.GameOver, Pass: true})
         }
+        game.Spectate(Reply{Action: "Move", Player: 1-player, Points: board.Points, LegalMoves: make([]int, 0), GameOver: game.GameOver, Pass: true})
     }
+    return nil
 }
 
+func Concede(game *Game, client *Client) error {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    if game.GameOver {
+        return Fail(ErrGameOver, "game is over")
+    }
+    game.Resign(player)
+    game.Broadcast(Reply{Action: "Concede", Player: player, GameOver: true})
+    return nil
+}
+
+func Chat(game *Game, client *Client, text string) error {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return Fail(ErrNotInGame, "not in game")
+    }
+    game.Broadcast(Reply{Action: "Chat", Player: player, Text: text})
+    return nil
+}
 
 func Socket(w http.ResponseWriter, r *http.Request) {
-    var player int
+    // Game this connection plays in
+    var current *Game
+    // Game this connection watches
+    var watching *Game
+    // Logged in account, empty if anonymous
+    var account string
     conn, err := upgrader.Upgrade(w, r, nil)
     if err != nil {
         log.Println(err)
         return
     }
     defer conn.Close()
+    client := NewClient(conn)
+    defer func() {
+        if current != nil {
+            Drop(current, client)
+        }
+        if watching != nil {
+            Unwatch(watching, client)
+        }
+    }()
+    // The game of a request, or an error if there is none
+    getGame := func(key int) (*Game, error) {
+        game := store.Get(key)
+        if game == nil {
+            return nil, Fail(ErrNoGame, fmt.Sprintf("no game %d", key))
+        }
+        return game, nil
+    }
+    // The game of a request if this connection plays in it
+    playing := func(key int) (*Game, error) {
+        game, err := getGame(key)
+        if err == nil && game != current {
+            err = Fail(ErrNotInGame, "not in game")
+        }
+        return game, err
+    }
     for {
         msgType, msg, err := conn.ReadMessage()
         if err != nil {
             log.Println(err)
             return
         }
+        var req Request
         if msgType != websocket.TextMessage {
-            log.Println("Not a text message")
-            return
+            client.Send(ErrorReply(&req, Fail(ErrBadRequest, "not a text message")))
+            continue
         }
-        var req Request
         err = json.Unmarshal(msg, &req)
         if err != nil {
-            log.Println(err)
-            return
+            client.Send(ErrorReply(&req, Fail(ErrBadRequest, err.Error())))
+            continue
+        }
+        // Replies straight back to the request echo its Id
+        send := func(reply Reply) {
+            reply.Id = req.Id
+            client.Send(reply)
         }
         switch req.Action {
         // List boards
         case "ListBoards":
-            boards := GetBoards()
-            reply := Reply{Action: "ListBoards", BoardNames: boards}
-            jsn, _ := json.Marshal(reply)
-            err = conn.WriteMessage(websocket.TextMessage, jsn)
-            if err != nil {
-                log.Println(err)
-                continue
-            }
+            boards := boardIndex.Dedupe(GetBoards())
+            send(Reply{Action: "ListBoards", BoardNames: boards})
         // Load a board plan
         case "LoadBoard":
-            name := req.BoardName
-            board, err := GetBoard(name)
-            if err != nil {
-                log.Println(err)
-                continue
+            var spec *ai.BoardSpec
+            spec, err = GetBoardSpec(req.BoardName)
+            if err == nil {
+                send(Reply{Action: "LoadBoard", BoardPlan: string(spec.Plan)})
             }
-            reply := Reply{Action: "LoadBoard", BoardPlan: board}
-            jsn, _ := json.Marshal(reply)
-            err = conn.WriteMessage(websocket.TextMessage, jsn)
-            if err != nil {
-                log.Println(err)
-                continue
-            }
-        // List available non-AI games
+        // List games to join or watch
         case "ListGames":
-            keys := make([]int, 0)
-            for key,game := range games {
-                // Check if game has not been joined by two players
-                // and is not an AI game
-                if len(game.Conns) < 2 && !game.AIGame && !game.GameOver {
-                    keys = append(keys, key)
-                }
-            }
-            reply := Reply{Action: "ListGames", Keys: keys}
-            jsn, _ := json.Marshal(reply)
-            err = conn.WriteMessage(websocket.TextMessage, jsn)
-            if err != nil {
-                log.Println(err)
-                continue
-            }
+            send(Reply{Action: "ListGames", Keys: store.Open(), Live: store.Live()})
         // Start a new 2-player or AI game
         case "NewGame":
-            player = 0
-            key := NextGameIdx()
             aiGame := req.AIGame
             name := req.BoardName
-            points := req.Points
-            ns := req.Neighbors
-            lines := ai.PointsToLinesGood(points, ns)
-            lines = ai.CullShortLines(lines)
-            // This and the parameter in continue lines has the potential 
-            // to hang line generation
-            for i := 0; i < 2; i++ {
-                lines = ai.CullEqualLines(lines)
-                lines = ai.CullSubsetLines(lines)
-                lines = ai.CombineLines(lines)
-            }
-            lines = ai.CullEqualLines(lines)
-            lines = ai.CullSubsetLines(lines)
-            board := &ai.Board{
-                Points: points,
-                Lines: lines,
-                Turn: 0,
-            }
-            plan, err := GetBoard(name)
-            if err != nil {
-                log.Println(err)
-                continue
+            var board *ai.Board
+            var plan string
+            board, plan, err = BoardFromRequest(req)
+            var engine ai.Engine
+            aiSeat := -1
+            if err == nil && aiGame {
+                engine, err = MakeEngine(req.Engine, req.Level)
+            }
+            if err == nil && aiGame {
+                aiSeat, err = AISeat(req.AIColor)
+            }
+            if err == nil && req.TimeControl != nil {
+                err = req.TimeControl.Validate()
             }
-            conns := make([]*websocket.Conn, 1)
-            conns[0] = conn
-            // Send and recv channels are from GameLoop's perspective
-            recvChan := make(chan bool)
-            game := &Game{Key: key, BoardName: name, BoardPlan: plan, Board: board, Conns: conns, RecvChan: recvChan, AIGame: aiGame}
-            games[key] = game
-            if aiGame {
-                sendChan := make(chan bool)
-                go ai.Loop(1, game.Board, sendChan, recvChan, 10, 2000)
-                go GameLoop(game, recvChan, sendChan)
-            }
-            moves := board.GetPossibleMoves()
-            game.GameOver = len(moves) == 0
-            reply := Reply{Action: "NewGame", Key: key, Points: board.Points, LegalMoves: moves, GameOver: game.GameOver}
-            jsn, _ := json.Marshal(reply)
-            err = conn.WriteMessage(websocket.TextMessage, jsn)
             if err != nil {
-                log.Println(err)
-                continue
+                break
+            }
+            // Starting another game leaves the current one
+            if current != nil {
+                Leave(current, client)
             }
+            current = NewGame(name, plan, board, client, aiSeat)
+            current.Fingerprint = boardIndex.GameFingerprint(name, board)
+            current.Engine = req.Engine
+            current.Level = req.Level
+            current.Names[current.CreatorSeat()] = account
+            if req.TimeControl != nil {
+                current.Clock = NewClock(*req.TimeControl)
+            }
+            store.Add(current)
+            StartGame(current, engine, req.Id)
         case "JoinGame":
-            player = 1
-            key := req.Key
-            game := games[key]
-            if game == nil {
-                log.Println("Game not found")
-                continue
+            var game *Game
+            game, err = getGame(req.Key)
+            if err != nil {
+                break
             }
-            if game.GameOver {
-                log.Println("Game is over")
-                continue
+            if game == current {
+                err = Fail(ErrNotAllowed, "already in game")
+                break
+            }
+            // The new seat is taken before the current game is left, so a
+            // failed join doesn't resign it
+            err = JoinGame(game, client, account, req.Id)
+            if err != nil {
+                break
             }
-            game.Conns = append(game.Conns, conn)
-            moves := game.Board.GetPossibleMoves()
-            gameOver := len(moves) == 0
-            if game.Board.Turn == 0 {
-                moves = make([]int, 0)
+            if current != nil {
+                Leave(current, client)
             }
-            reply := Reply{Action: "JoinGame", Key: key, BoardPlan: game.BoardPlan, Points: game.Board.Points, LegalMoves: moves, GameOver: gameOver}
-            jsn, _ := json.Marshal(reply)
-            err := conn.WriteMessage(websocket.TextMessage, jsn)
+            current = game
+            if watching == game {
+                Unwatch(game, client)
+                watching = nil
+            }
+        // Back in a game after a dropped connection
+        case "Rejoin":
+            var game *Game
+            game, err = getGame(req.Key)
             if err != nil {
-                log.Println(err)
-                continue
+                break
+            }
+            err = Rejoin(game, client, req.Token, req.Id)
+            if err != nil {
+                break
+            }
+            if current != nil && current != game {
+                Leave(current, client)
+            }
+            current = game
+            if watching == game {
+                Unwatch(game, client)
+                watching = nil
+            }
+        // Spectate, moves are refused since the client has no seat
+        case "Watch":
+            var game *Game
+            game, err = getGame(req.Key)
+            if err != nil {
+                break
+            }
+            if game == current || game == watching {
+                err = Fail(ErrNotAllowed, "already in game")
+                break
+            }
+            if watching != nil {
+                Unwatch(watching, client)
             }
+            watching = game
+            Watch(game, client, req.Id)
         // Move
         case "Move":
-            key := req.Key
-            move := req.Move
-            game := games[key]
-            if game.GameOver {
-                log.Println("Game is over")
-                continue
-            }
-            // Check if the move is legal and make the move
-            if !game.Board.MoveIsLegal(move) {
-                log.Println("Illegal move")
-                continue
+            var game *Game
+            game, err = playing(req.Key)
+            if err == nil {
+                err = MakeMove(game, client, req.Move)
             }
-            game.Board.MakeMove(move)
-            moves := game.Board.GetPossibleMoves()
-            game.GameOver = len(moves) == 0
-            reply := Reply{Action: "Move", Player: player, Points: game.Board.Points, LegalMoves: make([]int, 0), GameOver: game.GameOver}
-            jsn, _ := json.Marshal(reply)
-            err := game.Conns[player].WriteMessage(websocket.TextMessage, jsn)
+        // Take back moves
+        case "Undo", "Takeback", "AcceptTakeback", "DeclineTakeback":
+            var game *Game
+            game, err = playing(req.Key)
             if err != nil {
-                log.Println(err)
-                continue
+                break
+            }
+            switch req.Action {
+            case "Undo":
+                err = Undo(game, client)
+            case "Takeback":
+                err = Takeback(game, client)
+            default:
+                err = AnswerTakeback(game, client, req.Action == "AcceptTakeback")
+            }
+        // Game record, of any game
+        case "Record":
+            var game *Game
+            game, err = getGame(req.Key)
+            if err == nil {
+                send(Reply{Action: "Record", Key: req.Key, Text: RecordText(game)})
+            }
+        // Accounts and ratings
+        case "Register":
+            var secret string
+            secret, err = accounts.Register(req.Name)
+            if err == nil {
+                account = req.Name
+                send(Reply{Action: "Register", Name: account, Secret: secret})
+            }
+        case "Login":
+            var name string
+            name, err = accounts.Login(req.Name, req.Secret)
+            if err == nil {
+                account = name
+                send(Reply{Action: "Login", Name: account})
+            }
+        case "Leaderboard":
+            fp := ""
+            if req.BoardName != "" {
+                fp, err = boardIndex.Lookup(req.BoardName)
+            }
+            if err == nil {
+                send(Reply{Action: "Leaderboard", Leaderboard: accounts.Leaderboard(fp, LeaderboardSize)})
             }
-            if game.AIGame {
-                if game.GameOver {
-                    game.RecvChan <- false
-                } else {
-                    game.RecvChan <- true
-                }
-            } else if len(game.Conns) == 2 {
-                reply = Reply{Action: "Move", Player: player, Points: game.Board.Points, LegalMoves: moves, GameOver: game.GameOver}
-                jsn, _ = json.Marshal(reply)
-                err = game.Conns[1-player].WriteMessage(websocket.TextMessage, jsn)
-                if err != nil {
-                    log.Println(err)
-                    continue
-                }
+        case "Profile":
+            profile := accounts.Profile(req.Name)
+            if profile == nil {
+                err = Fail(ErrAccount, "no such player")
+                break
             }
+            send(Reply{Action: "Profile", Profile: profile})
         // Concede
         case "Concede":
-            key := req.Key
-            game := games[key]
-            game.GameOver = true
-            reply := Reply{Action: "Concede", Player: player, GameOver: true}
-            jsn, _ := json.Marshal(reply)
-            err := game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
-            if err != nil {
-                log.Println(err)
-                continue
-            }
-            if game.AIGame {
-                game.RecvChan <- false
-            } else if len(game.Conns) == 2 {
-                err = game.Conns[1].WriteMessage(websocket.TextMessage, jsn)
-                if err != nil {
-                    log.Println(err)
-                    continue
-                }
+            var game *Game
+            game, err = playing(req.Key)
+            if err == nil {
+                err = Concede(game, client)
             }
         // Chat
         case "Chat":
-            key := req.Key
-            text := req.Text
-            game := games[key]
-            reply := Reply{Action: "Chat", Player: player, Text: text}
-            jsn, _ := json.Marshal(reply)
-            err := game.Conns[0].WriteMessage(websocket.TextMessage, jsn)
-            if err != nil {
-                log.Println(err)
-                continue
-            }
-            if len(game.Conns) == 2 {
-                err = game.Conns[1].WriteMessage(websocket.TextMessage, jsn)
-                if err != nil {
-                    log.Println(err)
-                    continue
-                }
+            var game *Game
+            game, err = playing(req.Key)
+            if err == nil {
+                err = Chat(game, client, req.Text)
             }
+        default:
+            err = Fail(ErrBadRequest, "unknown action " + req.Action)
+        }
+        if err != nil {
+            log.Println(req.Action, err)
+            client.Send(ErrorReply(&req, err))
         }
     }
 }
@@ -406,8 +670,35 @@ func ServeLocalFiles(dirs []string) {
 }
 
 func main() {
+    gamesDir := flag.String("games", "../games", "directory to save games in, empty to keep them in memory")
+    accountsFile := flag.String("accounts", "../accounts.json", "file to save accounts in, empty to keep them in memory")
+    flag.Parse()
     log.SetFlags(0)
+    if *accountsFile != "" {
+        accounts.Path = *accountsFile
+        err := accounts.Load()
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
+    // Ratings kept by board name move to fingerprints once every board is built
+    go func() {
+        boardIndex.Build(GetBoards())
+        accounts.Rekey(boardIndex.Lookup)
+    }()
+    if *gamesDir != "" {
+        p, err := NewFilePersister(*gamesDir)
+        if err != nil {
+            log.Fatal(err)
+        }
+        store.Persist = p
+        err = store.RestoreGames()
+        if err != nil {
+            log.Println(err)
+        }
+    }
     ServeLocalFiles([]string{"", "/js", "/css"})
     http.HandleFunc("/ws", Socket)
+    http.HandleFunc("/record", DownloadRecord)
     log.Fatal(http.ListenAndServe(":8003", nil))
 }
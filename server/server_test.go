@@ -0,0 +1,508 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+const testBoard = "David's Original Board"
+
+// Start position of testBoard, see ai.Board.PlaceStart
+func startPoints(t *testing.T) []ai.Point {
+    plan, err := GetBoard(testBoard)
+    if err != nil {
+        t.Fatal(err)
+    }
+    board, _, err := ai.MakeFromPlan(plan)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if black, _ := board.PlaceStart(); black == nil {
+        t.Fatal("no start position")
+    }
+    return board.Points
+}
+
+func testServer(t *testing.T) string {
+    store = NewGameStore()
+    accounts = NewAccountStore()
+    boardIndex = NewBoardIndex()
+    boardIndex.Build([]string{testBoard})
+    AITimeMillis = 20
+    // Handlers of the last test must be gone before store is replaced
+    var handlers sync.WaitGroup
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handlers.Add(1)
+        defer handlers.Done()
+        Socket(w, r)
+    }))
+    t.Cleanup(func() {
+        srv.Close()
+        handlers.Wait()
+    })
+    return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(url string) (*websocket.Conn, error) {
+    conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+    return conn, err
+}
+
+func read(conn *websocket.Conn) (Reply, error) {
+    var reply Reply
+    conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+    _, msg, err := conn.ReadMessage()
+    if err != nil {
+        return reply, err
+    }
+    err = json.Unmarshal(msg, &reply)
+    return reply, err
+}
+
+// Plays random legal moves until the game ends, concedes after concede moves if positive
+func play(conn *websocket.Conn, key int, reply Reply, rng *rand.Rand, concede int) error {
+    var err error
+    for moves := 0; !reply.GameOver; {
+        if len(reply.LegalMoves) > 0 {
+            if moves == concede {
+                conn.WriteJSON(Request{Action: "Concede", Key: key})
+            } else {
+                move := reply.LegalMoves[rng.Intn(len(reply.LegalMoves))]
+                conn.WriteJSON(Request{Action: "Move", Key: key, Move: move})
+                moves++
+            }
+        }
+        reply, err = read(conn)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func TestGameStore(t *testing.T) {
+    s := NewGameStore()
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            board := ai.MakeTraditional(8)
+            aiSeat := -1
+            if i % 2 == 0 {
+                aiSeat = 1
+            }
+            for j := 0; j < 50; j++ {
+                game := NewGame(testBoard, "", board.Clone(), &Client{}, aiSeat)
+                key := s.Add(game)
+                if s.Get(key) != game {
+                    t.Error("Get returned a different game")
+                    return
+                }
+                s.Open()
+                if j % 2 == 0 {
+                    s.Remove(key)
+                    if s.Get(key) != nil {
+                        t.Error("game not removed")
+                        return
+                    }
+                }
+            }
+        }(i)
+    }
+    wg.Wait()
+    if s.Len() != 8*25 {
+        t.Errorf("%d games, expected %d", s.Len(), 8*25)
+    }
+    open := s.Open()
+    if len(open) != 4*25 {
+        t.Errorf("%d open games, expected %d", len(open), 4*25)
+    }
+    for i := 1; i < len(open); i++ {
+        if open[i] <= open[i-1] {
+            t.Fatal("open games not in order")
+        }
+    }
+}
+
+// Clients get the plan of a board file that declares its lines
+func TestLoadBoardSpec(t *testing.T) {
+    name := "10x Almost Classic, polygon lines"
+    url := testServer(t)
+    conn, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    conn.WriteJSON(Request{Action: "LoadBoard", BoardName: name})
+    reply, err := read(conn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ai.ParsePlan(reply.BoardPlan); reply.Action != "LoadBoard" || err != nil {
+        t.Fatalf("%v: %v", reply.Action, err)
+    }
+    spec, err := GetBoardSpec(name)
+    if err != nil || spec.Lines != ai.PolygonLines {
+        t.Fatalf("%v %v", spec, err)
+    }
+}
+
+// Of two boards that play the same only the lower name is listed
+func TestBoardIndex(t *testing.T) {
+    idx := NewBoardIndex()
+    names := []string{"needs rhombus or triangular snub", testBoard, "big egg verticie pods"}
+    done := make(chan []string)
+    go func() {
+        done <- idx.Dedupe(names)
+    }()
+    if idx.Ready() {
+        t.Fatal("ready before Build")
+    }
+    idx.Build(append(names, "no such board"))
+    got := <-done
+    if len(got) != 2 || got[0] != testBoard || got[1] != "big egg verticie pods" {
+        t.Fatalf("got %v", got)
+    }
+    a, err := idx.Lookup(names[0])
+    if b, _ := idx.Lookup(names[2]); err != nil || a != b {
+        t.Fatal("duplicate boards", a, b, err)
+    }
+    if _, err := idx.Lookup("no such board"); err == nil {
+        t.Error("missing board fingerprinted")
+    }
+}
+
+// Many games and list requests at once, run with -race
+func TestConcurrentClients(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    games := 8
+    if testing.Short() {
+        games = 3
+    }
+    errs := make(chan error, 4*games)
+    var wg sync.WaitGroup
+    twoPlayer := func(i int) {
+        defer wg.Done()
+        rng := rand.New(rand.NewSource(int64(i)))
+        creator, err := dial(url)
+        if err != nil {
+            errs <- err
+            return
+        }
+        defer creator.Close()
+        creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+        reply, err := read(creator)
+        if err != nil || reply.Action != "NewGame" || reply.Key < 0 {
+            errs <- fmt.Errorf("game %d: new game %v %v", i, reply, err)
+            return
+        }
+        key := reply.Key
+        joiner, err := dial(url)
+        if err != nil {
+            errs <- err
+            return
+        }
+        defer joiner.Close()
+        joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+        joined, err := read(joiner)
+        if err != nil || joined.Action != "JoinGame" {
+            errs <- fmt.Errorf("game %d: join %v %v", i, joined, err)
+            return
+        }
+        concede := -1
+        if i % 3 == 0 {
+            concede = 3
+        }
+        done := make(chan error, 1)
+        go func() {
+            done <- play(joiner, key, joined, rand.New(rand.NewSource(int64(-i))), concede)
+        }()
+        if err := play(creator, key, reply, rng, -1); err != nil {
+            errs <- fmt.Errorf("game %d: creator %v", i, err)
+        }
+        if err := <-done; err != nil {
+            errs <- fmt.Errorf("game %d: joiner %v", i, err)
+        }
+    }
+    aiGame := func(i int) {
+        defer wg.Done()
+        conn, err := dial(url)
+        if err != nil {
+            errs <- err
+            return
+        }
+        defer conn.Close()
+        engine := "alphabeta"
+        if i % 2 == 1 {
+            engine = "mcts"
+        }
+        conn.WriteJSON(Request{Action: "NewGame", AIGame: true, BoardName: testBoard, Points: points, Engine: engine})
+        reply, err := read(conn)
+        if err != nil || reply.Key < 0 {
+            errs <- fmt.Errorf("ai game %d: new game %v %v", i, reply, err)
+            return
+        }
+        // Some players leave mid game, the AI must stop
+        if i % 3 == 0 {
+            if len(reply.LegalMoves) > 0 {
+                conn.WriteJSON(Request{Action: "Move", Key: reply.Key, Move: reply.LegalMoves[0]})
+            }
+            return
+        }
+        // Whole AI games take too long
+        if err := play(conn, reply.Key, reply, rand.New(rand.NewSource(int64(i))), 8); err != nil {
+            errs <- fmt.Errorf("ai game %d: %v", i, err)
+        }
+    }
+    lister := func() {
+        defer wg.Done()
+        conn, err := dial(url)
+        if err != nil {
+            errs <- err
+            return
+        }
+        defer conn.Close()
+        for j := 0; j < 50; j++ {
+            conn.WriteJSON(Request{Action: "ListGames"})
+            if _, err := read(conn); err != nil {
+                errs <- err
+                return
+            }
+            conn.WriteJSON(Request{Action: "JoinGame", Key: -1-j})
+        }
+    }
+    for i := 0; i < games; i++ {
+        wg.Add(3)
+        go twoPlayer(i)
+        go aiGame(i)
+        go lister()
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+    // Disconnects are handled after the socket read fails
+    // Dropped games wait for a Rejoin
+    deadline := time.Now().Add(5 * time.Second)
+    for key := 0; key < store.next; key++ {
+        game := store.Get(key)
+        if game == nil {
+            continue
+        }
+        for {
+            game.Lock()
+            done := game.GameOver && game.Ctx.Err() != nil
+            dropped := game.FreeSeat() == -1 && game.SeatOf(nil) == 0
+            game.Unlock()
+            if done || dropped {
+                break
+            }
+            if time.Now().After(deadline) {
+                t.Fatalf("game %d not over", key)
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+}
+
+func TestRejoin(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer creator.Close()
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+    reply, err := read(creator)
+    if err != nil || reply.Token == "" {
+        t.Fatal("no token", err)
+    }
+    key := reply.Key
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+    joined, err := read(joiner)
+    if err != nil || joined.Token == "" || joined.Token == reply.Token {
+        t.Fatal("bad token", err)
+    }
+    creator.WriteJSON(Request{Action: "Move", Key: key, Move: reply.LegalMoves[0]})
+    read(creator)
+    if _, err := read(joiner); err != nil {
+        t.Fatal(err)
+    }
+
+    joiner.Close()
+    if notice, err := read(creator); err != nil || notice.Action != "Disconnect" || notice.Player != 1 {
+        t.Fatal("no disconnect notice", notice.Action, err)
+    }
+    // The dropped seat isn't open to others
+    if open := store.Open(); len(open) != 0 {
+        t.Fatalf("open games %v", open)
+    }
+
+    rejoined, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rejoined.Close()
+    // Wrong tokens are refused, a chat shows the seat is still empty
+    rejoined.WriteJSON(Request{Action: "Rejoin", Key: key, Token: reply.Token + "x"})
+    if refused, err := read(rejoined); err != nil || refused.Code != ErrBadToken {
+        t.Fatal("wrong token", refused.Action, err)
+    }
+    creator.WriteJSON(Request{Action: "Chat", Key: key, Text: "hello"})
+    if chat, err := read(creator); err != nil || chat.Action != "Chat" {
+        t.Fatal(chat.Action, err)
+    }
+    rejoined.WriteJSON(Request{Action: "Rejoin", Key: key, Token: joined.Token})
+    state, err := read(rejoined)
+    if err != nil || state.Action != "Rejoin" || state.Player != 1 || state.Turn != 1 || len(state.LegalMoves) == 0 {
+        t.Fatalf("rejoin %v %d %d %v", state.Action, state.Player, state.Turn, err)
+    }
+    if notice, err := read(creator); err != nil || notice.Action != "Reconnect" || notice.Player != 1 {
+        t.Fatal("no reconnect notice", notice.Action, err)
+    }
+    rejoined.WriteJSON(Request{Action: "Move", Key: key, Move: state.LegalMoves[0]})
+    if moved, err := read(creator); err != nil || moved.Action != "Move" || moved.Player != 1 {
+        t.Fatal("move after rejoin", moved.Action, err)
+    }
+}
+
+func TestWatch(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    creator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer creator.Close()
+    creator.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+    reply, err := read(creator)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key := reply.Key
+    joiner, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer joiner.Close()
+    joiner.WriteJSON(Request{Action: "JoinGame", Key: key})
+    if _, err := read(joiner); err != nil {
+        t.Fatal(err)
+    }
+
+    spectator, err := dial(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer spectator.Close()
+    spectator.WriteJSON(Request{Action: "ListGames"})
+    list, err := read(spectator)
+    if err != nil || len(list.Keys) != 0 || len(list.Live) != 1 || list.Live[0] != key {
+        t.Fatalf("list %v %v %v", list.Keys, list.Live, err)
+    }
+    spectator.WriteJSON(Request{Action: "Watch", Key: key})
+    state, err := read(spectator)
+    if err != nil || state.Action != "Watch" || state.Turn != 0 || len(state.Points) != len(points) {
+        t.Fatal("watch", state.Action, err)
+    }
+
+    creator.WriteJSON(Request{Action: "Move", Key: key, Move: reply.LegalMoves[0]})
+    read(creator)
+    opp, err := read(joiner)
+    if err != nil {
+        t.Fatal(err)
+    }
+    moved, err := read(spectator)
+    if err != nil || moved.Action != "Move" || moved.Player != 0 || len(moved.LegalMoves) != 0 {
+        t.Fatal("spectator move", moved.Action, err)
+    }
+
+    // Spectators can't move for the player to move
+    spectator.WriteJSON(Request{Action: "Move", Key: key, Move: opp.LegalMoves[0]})
+    if refused, err := read(spectator); err != nil || refused.Code != ErrNotInGame {
+        t.Fatal("spectator moved", refused.Action, err)
+    }
+    joiner.WriteJSON(Request{Action: "Chat", Key: key, Text: "hi"})
+    for _,conn := range []*websocket.Conn{creator, spectator} {
+        if chat, err := read(conn); err != nil || chat.Action != "Chat" {
+            t.Fatal("expected chat", chat.Action, err)
+        }
+    }
+    creator.WriteJSON(Request{Action: "Concede", Key: key})
+    if end, err := read(spectator); err != nil || end.Action != "Concede" || !end.GameOver {
+        t.Fatal("expected concede", end.Action, err)
+    }
+}
+
+// Joining another game leaves the current one, but only once the join worked
+func TestFailedJoinKeepsGame(t *testing.T) {
+    url := testServer(t)
+    points := startPoints(t)
+    conns := make([]*websocket.Conn, 5)
+    for i := range conns {
+        conn, err := dial(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conns[i] = conn
+    }
+    expect := func(conn *websocket.Conn, action string) Reply {
+        t.Helper()
+        reply, err := read(conn)
+        if err != nil || reply.Action != action {
+            t.Fatalf("expected %s, got %s %s %v", action, reply.Action, reply.Code, err)
+        }
+        return reply
+    }
+    newGame := func(conn *websocket.Conn) int {
+        t.Helper()
+        conn.WriteJSON(Request{Action: "NewGame", BoardName: testBoard, Points: points})
+        return expect(conn, "NewGame").Key
+    }
+    player, opp := conns[0], conns[1]
+    current := newGame(opp)
+    player.WriteJSON(Request{Action: "JoinGame", Key: current})
+    expect(player, "JoinGame")
+    full := newGame(conns[2])
+    conns[3].WriteJSON(Request{Action: "JoinGame", Key: full})
+    expect(conns[3], "JoinGame")
+
+    player.WriteJSON(Request{Action: "JoinGame", Key: full})
+    if reply := expect(player, "Error"); reply.Code != ErrNotAllowed {
+        t.Fatal("joined a full game", reply.Code)
+    }
+    player.WriteJSON(Request{Action: "Rejoin", Key: full, Token: "nope"})
+    if reply := expect(player, "Error"); reply.Code != ErrBadToken {
+        t.Fatal("rejoined with a bad token", reply.Code)
+    }
+    // The opponent gets the chat, not a concede
+    player.WriteJSON(Request{Action: "Chat", Key: current, Text: "still here"})
+    expect(player, "Chat")
+    expect(opp, "Chat")
+
+    open := newGame(conns[4])
+    player.WriteJSON(Request{Action: "JoinGame", Key: open})
+    expect(player, "JoinGame")
+    if reply := expect(opp, "Concede"); reply.Player != 1 || !reply.GameOver {
+        t.Fatal("left game not conceded", reply.Player)
+    }
+}
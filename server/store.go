@@ -0,0 +1,502 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+    ai "github.com/aorliche/web-nongrid-othello/ai"
+)
+
+// Lock order is GameStore, then Game, then AccountStore or Client
+
+// Websocket connections allow one writer at a time
+type Client struct {
+    Conn *websocket.Conn
+    mutex sync.Mutex
+}
+
+func NewClient(conn *websocket.Conn) *Client {
+    return &Client{Conn: conn}
+}
+
+func (c *Client) Send(reply Reply) error {
+    jsn, _ := json.Marshal(reply)
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    err := c.Conn.WriteMessage(websocket.TextMessage, jsn)
+    if err != nil {
+        log.Println(err)
+    }
+    return err
+}
+
+// The embedded mutex guards the board, connections and game state
+type Game struct {
+    sync.Mutex
+    Key int
+    BoardName string
+    BoardPlan string
+    // Of the board's points and lines, see ai.Fingerprint
+    Fingerprint string
+    Board *ai.Board
+    // Starting position and every move since, including passes
+    Start []ai.Point
+    Log []LoggedMove
+    // Seat that asked for a takeback, -1 if none
+    Takeback int
+    // Indexed by seat, nil if the seat is free or its player dropped
+    Conns []*Client
+    // Secret of each seat for Rejoin, empty if the seat was never taken
+    Tokens []string
+    // Account of each seat, empty for anonymous players
+    Names []string
+    // Spectators, they get every Move, Concede and Chat but can't play
+    Watchers []*Client
+    // Wakes GameLoop after a human move
+    Moved chan bool
+    AIGame bool
+    // Seat the AI plays, -1 in two player games
+    AISeat int
+    Engine string
+    Level string
+    GameOver bool
+    // Set when a player resigns or runs out of time, otherwise the score decides
+    Result string
+    // Seat that resigned or ran out of time, -1 if none
+    Loser int
+    // Ratings were updated
+    Rated bool
+    // nil without a time control
+    Clock *Clock
+    // Set by RestoreGames, the clock waits until the seat to move is back
+    held bool
+    Created time.Time
+    // Done on concede, disconnect, expiry or game over, stops the AI
+    Ctx context.Context
+    Cancel context.CancelFunc
+    Expiry *time.Timer
+    // Set by the store
+    persist Persister
+}
+
+// Games with no moves for this long are ended and removed
+const GameIdle = 30 * time.Minute
+
+// aiSeat is the AI's seat in AI games, -1 in two player games
+// The creator takes the other seat, seat 0 in two player games
+// Fingerprint is left to the caller, see BoardIndex.GameFingerprint
+func NewGame(name string, plan string, board *ai.Board, client *Client, aiSeat int) *Game {
+    ctx, cancel := context.WithCancel(context.Background())
+    start := make([]ai.Point, len(board.Points))
+    copy(start, board.Points)
+    conns := make([]*Client, 2)
+    tokens := make([]string, 2)
+    seat := 0
+    if aiSeat == 0 {
+        seat = 1
+    }
+    conns[seat] = client
+    if client != nil {
+        tokens[seat] = NewToken()
+    }
+    return &Game{
+        BoardName: name,
+        BoardPlan: plan,
+        Board: board,
+        Start: start,
+        Log: make([]LoggedMove, 0),
+        Takeback: -1,
+        Conns: conns,
+        Tokens: tokens,
+        Names: make([]string, len(conns)),
+        Loser: -1,
+        Moved: make(chan bool, 1),
+        AIGame: aiSeat != -1,
+        AISeat: aiSeat,
+        Created: time.Now(),
+        Ctx: ctx,
+        Cancel: cancel,
+    }
+}
+
+// Methods below need the game locked
+
+func (game *Game) Stop() {
+    game.GameOver = true
+    if game.Clock != nil {
+        game.Clock.Halt(time.Now())
+    }
+    game.Cancel()
+    game.Rate()
+    game.Save()
+}
+
+// Move must be legal, or Pass
+// A move cancels any takeback request
+func (game *Game) Play(move int) {
+    board := game.Board
+    entry := LoggedMove{Ply: len(game.Log), Player: board.Turn % 2, Move: move, Flips: board.Flips(move)}
+    board.MakeMove(move)
+    game.Log = append(game.Log, entry)
+    game.Takeback = -1
+}
+
+// Seats of dropped players are kept for Rejoin
+func (game *Game) FreeSeat() int {
+    for i,c := range game.Conns {
+        if c == nil && game.Tokens[i] == "" && i != game.AISeat {
+            return i
+        }
+    }
+    return -1
+}
+
+// Seat of the player who started the game
+func (game *Game) CreatorSeat() int {
+    if game.AISeat == 0 {
+        return 1
+    }
+    return 0
+}
+
+// -1 if the client has no seat
+func (game *Game) SeatOf(client *Client) int {
+    for i,c := range game.Conns {
+        if c == client {
+            return i
+        }
+    }
+    return -1
+}
+
+func (game *Game) SeatFor(token string) int {
+    for i,t := range game.Tokens {
+        if token != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+            return i
+        }
+    }
+    return -1
+}
+
+func (game *Game) Touch() {
+    game.Expiry.Reset(GameIdle)
+}
+
+// Clocks go with every reply of a game
+func (game *Game) stamp(reply *Reply) {
+    if game.Clock != nil {
+        reply.Clock = game.Clock.State(time.Now())
+    }
+}
+
+func (game *Game) Send(player int, reply Reply) {
+    game.stamp(&reply)
+    if player < len(game.Conns) && game.Conns[player] != nil {
+        game.Conns[player].Send(reply)
+    }
+}
+
+// Players and spectators
+func (game *Game) Broadcast(reply Reply) {
+    game.stamp(&reply)
+    for _,c := range game.Conns {
+        if c != nil {
+            c.Send(reply)
+        }
+    }
+    game.Spectate(reply)
+}
+
+// Spectators only, for replies that differ by seat like Move
+func (game *Game) Spectate(reply Reply) {
+    game.stamp(&reply)
+    for _,c := range game.Watchers {
+        c.Send(reply)
+    }
+}
+
+// Doesn't block, GameLoop checks the board when it wakes
+func (game *Game) Wake() {
+    select {
+    case game.Moved <- true:
+    default:
+    }
+}
+
+type GameStore struct {
+    mutex sync.Mutex
+    games map[int]*Game
+    next int
+    // Saves every game added, nil keeps games in memory only
+    Persist Persister
+}
+
+func NewGameStore() *GameStore {
+    return &GameStore{games: make(map[int]*Game)}
+}
+
+var store = NewGameStore()
+
+// Assigns the key and starts the idle timer
+func (store *GameStore) Add(game *Game) int {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    game.Key = store.next
+    store.insert(game)
+    return game.Key
+}
+
+// Adds a game that already has a key, e.g. from a Persister
+func (store *GameStore) Restore(game *Game) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    store.insert(game)
+}
+
+// Keeps new games from taking key, e.g. of a finished game still on disk
+func (store *GameStore) Reserve(key int) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    store.reserve(key)
+}
+
+func (store *GameStore) reserve(key int) {
+    if key >= store.next {
+        store.next = key+1
+    }
+}
+
+func (store *GameStore) insert(game *Game) {
+    key := game.Key
+    game.persist = store.Persist
+    store.reserve(key)
+    game.Expiry = time.AfterFunc(GameIdle, func() {
+        log.Println("game", key, "expired")
+        game.Lock()
+        game.Stop()
+        game.Unlock()
+        store.Remove(key)
+    })
+    store.games[key] = game
+}
+
+// nil if not found
+func (store *GameStore) Get(key int) *Game {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    return store.games[key]
+}
+
+func (store *GameStore) Remove(key int) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    delete(store.games, key)
+}
+
+func (store *GameStore) Len() int {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    return len(store.games)
+}
+
+// Games with a free seat, in order
+func (store *GameStore) Open() []int {
+    return store.keys(func(game *Game) bool {
+        return game.FreeSeat() != -1 && !game.GameOver
+    })
+}
+
+// Games in progress that can be watched, in order
+func (store *GameStore) Live() []int {
+    return store.keys(func(game *Game) bool {
+        return !game.GameOver
+    })
+}
+
+// The filter is called with the game locked
+func (store *GameStore) keys(filter func(*Game) bool) []int {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+    keys := make([]int, 0)
+    for key,game := range store.games {
+        game.Lock()
+        if filter(game) {
+            keys = append(keys, key)
+        }
+        game.Unlock()
+    }
+    sort.Ints(keys)
+    return keys
+}
+
+// Longest search of server AIs without a clock, levels take up to this
+var AITimeMillis = 5000
+
+// AI engines by name, alpha-beta if empty, and level, DefaultLevel if empty
+func MakeEngine(name string, levelName string) (ai.Engine, error) {
+    level, err := GetLevel(levelName)
+    if err != nil {
+        return nil, err
+    }
+    timeMillis := level.TimeMillis
+    if timeMillis > AITimeMillis {
+        timeMillis = AITimeMillis
+    }
+    eval := evaluators[level.Eval]
+    var engine ai.Engine
+    switch name {
+    case "", "alphabeta":
+        engine = &ai.AlphaBeta{Depth: level.Depth, TimeMillis: timeMillis, Eval: eval, Table: ai.NewTranspositionTable(ai.TableSize), Endgame: level.Endgame}
+    case "mcts":
+        engine = &ai.MCTS{TimeMillis: timeMillis, Rollout: eval, Seed: time.Now().UnixNano()}
+    default:
+        return nil, errors.New("unknown engine " + name)
+    }
+    if level.Blunder > 0 {
+        engine = &ai.Blunder{Engine: engine, Rate: level.Blunder, Seed: time.Now().UnixNano()}
+    }
+    return engine, nil
+}
+
+// Plays the AI in its seat until the game ends or its context is done
+// The engine searches a clone so the board only changes under the game lock
+func GameLoop(game *Game, engine ai.Engine) {
+    game.Lock()
+    me := game.AISeat
+    game.Unlock()
+    human := 1-me
+    for {
+        game.Lock()
+        board := game.Board
+        if !game.GameOver && board.Turn % 2 == human && board.MustPass() {
+            game.Play(ai.Pass)
+            game.RunClock()
+            game.Save()
+            reply := Reply{Action: "Move", Player: human, Points: board.Points, LegalMoves: make([]int, 0), Pass: true}
+            game.Send(human, reply)
+            game.Spectate(reply)
+        }
+        if game.GameOver || board.GameOver() {
+            log.Println("game over", game.Key, board.GetScores())
+            game.Stop()
+            game.Unlock()
+            return
+        }
+        prev := board.Clone()
+        budget := 0
+        if game.Clock != nil {
+            budget = game.Clock.Budget(me, time.Now(), prev.Empties())
+        }
+        game.Unlock()
+        if prev.Turn % 2 == human {
+            select {
+            case <-game.Moved:
+            case <-game.Ctx.Done():
+                return
+            }
+            continue
+        }
+        // The time per move comes from the AI's clock if there is one
+        if timed, ok := engine.(ai.Timed); ok && budget > 0 {
+            timed.SetTimeMillis(budget)
+        }
+        next := engine.Search(game.Ctx, prev.Clone(), me)
+        if game.Ctx.Err() != nil {
+            return
+        }
+        if next == nil {
+            time.Sleep(100 * time.Millisecond)
+            continue
+        }
+        game.Lock()
+        // The board may have been taken back while the AI searched
+        if !game.GameOver && game.Board.Hash() == prev.Hash() && game.PressClock(me) {
+            move := MovePlayed(prev, next)
+            game.Play(move)
+            game.Touch()
+            board = game.Board
+            game.GameOver = board.GameOver()
+            if game.GameOver {
+                game.Stop()
+            } else {
+                game.RunClock()
+            }
+            game.Save()
+            reply := Reply{Action: "Move", Player: me, Points: board.Points, LegalMoves: MovesFor(board, human), GameOver: game.GameOver, Pass: move == ai.Pass}
+            game.Send(human, reply)
+            reply.LegalMoves = make([]int, 0)
+            game.Spectate(reply)
+        }
+        game.Unlock()
+    }
+}
+
+// Starting or joining another game concedes this one
+func Leave(game *Game, client *Client) {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return
+    }
+    game.Conns[player] = nil
+    if game.GameOver {
+        return
+    }
+    game.Resign(player)
+    game.Broadcast(Reply{Action: "Concede", Player: player, GameOver: true})
+}
+
+// A dropped connection keeps its seat, the player can Rejoin until the game expires
+func Drop(game *Game, client *Client) {
+    game.Lock()
+    defer game.Unlock()
+    player := game.SeatOf(client)
+    if player == -1 {
+        return
+    }
+    game.Conns[player] = nil
+    if !game.GameOver {
+        game.Broadcast(Reply{Action: "Disconnect", Player: player})
+    }
+}
+
+// Sends the board to a new spectator
+func Watch(game *Game, client *Client, id int) {
+    game.Lock()
+    defer game.Unlock()
+    game.Watchers = append(game.Watchers, client)
+    board := game.Board
+    reply := Reply{Id: id, Action: "Watch", Key: game.Key, BoardPlan: game.BoardPlan, Points: board.Points, LegalMoves: make([]int, 0), Turn: board.Turn, Log: game.Log, Names: game.Names, GameOver: game.GameOver}
+    game.stamp(&reply)
+    client.Send(reply)
+}
+
+func Unwatch(game *Game, client *Client) {
+    game.Lock()
+    defer game.Unlock()
+    for i,c := range game.Watchers {
+        if c == client {
+            game.Watchers = append(game.Watchers[:i], game.Watchers[i+1:]...)
+            return
+        }
+    }
+}
+
+// Random hex, hard to guess
+func NewToken() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
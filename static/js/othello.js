@@ -10,7 +10,14 @@ let board = null;
 let me = null;
 let boardName = null;
 let key = null;
+// Seat token to Rejoin with after the connection drops
+let token = null;
+// Undo in AI games, ask the opponent in two player games
+let aiGame = false;
 let legalMoves = [];
+// Last clock from the server and when it arrived
+let clock = null;
+let clockTime = 0;
    
 function initBoard(board, boardPlan) {
     const fn = (typ, n) => {
@@ -105,17 +112,76 @@ function displayScores(board) {
     $('#white').innerText = scores[1];
 }
 
+// Options of the time control select are "mode main [increment | periods period]"
+function timeControl() {
+    const value = $('#time-control').value;
+    if (!value) return null;
+    const [mode, main, a, b] = value.split(' ');
+    const tc = {Mode: mode, MainMillis: parseInt(main)};
+    if (mode == 'fischer') {
+        tc.IncrementMillis = parseInt(a);
+    } else if (mode == 'byoyomi') {
+        tc.Periods = parseInt(a);
+        tc.PeriodMillis = parseInt(b);
+    }
+    return tc;
+}
+
+function formatMillis(ms) {
+    const s = Math.max(0, Math.ceil(ms/1000));
+    return `${Math.floor(s/60)}:${String(s%60).padStart(2, '0')}`;
+}
+
+// Counts down locally between replies
+function displayClock() {
+    if (!clock) {
+        $('#clock-black').innerText = '';
+        $('#clock-white').innerText = '';
+        return;
+    }
+    ['black', 'white'].forEach((color, i) => {
+        let left = clock.Left[i];
+        if (clock.Running == i) {
+            left -= Date.now() - clockTime;
+        }
+        let text = formatMillis(left);
+        if (clock.Mode == 'byoyomi') {
+            text += ` (${clock.Periods[i]})`;
+        }
+        $(`#clock-${color}`).innerText = text;
+    });
+}
+
 function gameOver(board) {
 
 }
 
+function saveRejoin() {
+    localStorage.setItem('rejoin', JSON.stringify({Key: key, Token: token}));
+}
+
+// Name and secret from Register, sent with Login on every connect
+function saveAccount(name, secret) {
+    localStorage.setItem('account', JSON.stringify({Name: name, Secret: secret}));
+}
+
+function chatNotice(text) {
+    $('#chat').value += `${text}\n`;
+    $('#chat').scrollTop = $('#chat').scrollHeight;
+}
+
 window.addEventListener('load', () => {
     const canvas = $('#canvas');
-    const conn = new WebSocket(`ws://${location.host}/ws`);
+    let conn = null;
     
-    conn.onmessage = e => {
+    const onMessage = e => {
         let gameOver = false;
         const json = JSON.parse(e.data);
+        if (json.Clock) {
+            clock = json.Clock;
+            clockTime = Date.now();
+            displayClock();
+        }
         switch (json.Action) {
             case 'ListBoards':
                 const boardNames = json.BoardNames;
@@ -165,6 +231,17 @@ window.addEventListener('load', () => {
                         select.appendChild(opt);
                     }
                 });
+                // Live games, keeping the selection
+                const live = $('select[name="live-list"]');
+                const selected = live.value;
+                live.innerHTML = '';
+                (json.Live || []).forEach(k => {
+                    const opt = document.createElement('option');
+                    opt.value = k;
+                    opt.innerHTML = `Game ${k}`;
+                    opt.selected = opt.value == selected;
+                    live.appendChild(opt);
+                });
                 break;
             case 'LoadBoard': {
                 const boardPlan = JSON.parse(json.BoardPlan);
@@ -172,15 +249,42 @@ window.addEventListener('load', () => {
                 initBoard(board, boardPlan);
                 break;
             }
+            case 'Error':
+                if (json.Request == 'NewGame') {
+                    drawText(board.canvas.getContext('2d'), 
+                        `Could not start game: ${json.Error}`, 
+                        new Point(canvas.width/2, 80),
+                        'red', 
+                        'bold 20px sans', 
+                        true);
+                    $('#new').disabled = false;
+                    $('#new-ai').disabled = false;
+                    break;
+                }
+                if (json.Request == 'Login') {
+                    localStorage.removeItem('account');
+                }
+                if (json.Request == 'Rejoin') {
+                    localStorage.removeItem('rejoin');
+                }
+                chatNotice(json.Error);
+                break;
             case 'NewGame':
                 key = json.Key;
-                me = 0;
+                token = json.Token;
+                clock = json.Clock;
+                clockTime = Date.now();
+                displayClock();
+                saveRejoin();
+                me = json.Player;
+                board.player = me == 0 ? "black" : "white";
                 displayScores(board);
                 legalMoves = json.LegalMoves;
                 gameOver = json.GameOver;
                 board.repaint();
                 break;
             case 'Move':
+            case 'Undo':
                 const player = json.Player;
                 const points = json.Points;
                 legalMoves = json.LegalMoves;
@@ -191,14 +295,32 @@ window.addEventListener('load', () => {
                        pt.player = null;
                    }
                 });
-                board.player = player == 0 ? "white" : "black";
                 board.repaint();
                 displayScores(board);
+                if (json.Pass) {
+                    const p = player == 0 ? 'Black' : 'White';
+                    chatNotice(`${p} has no moves and passes`);
+                }
+                if (json.Action == 'Undo') {
+                    const p = player == 0 ? 'Black' : 'White';
+                    chatNotice(`${p} took back a move`);
+                }
                 gameOver = json.GameOver;
                 break;
-            case 'JoinGame': {
+            case 'JoinGame':
+            case 'Rejoin':
+            case 'Watch': {
                 key = json.Key; 
-                me = 1;
+                clock = json.Clock;
+                clockTime = Date.now();
+                displayClock();
+                // Spectators have no color and no moves
+                me = json.Action == 'Watch' ? null : json.Player;
+                aiGame = json.AIGame;
+                if (json.Token) {
+                    token = json.Token;
+                    saveRejoin();
+                }
                 const boardPlan = JSON.parse(json.BoardPlan);
                 const points = json.Points;
                 legalMoves = json.LegalMoves;
@@ -209,7 +331,7 @@ window.addEventListener('load', () => {
                         pt.player = points[i].Player == 0 ? "black" : "white";
                     }
                 });
-                board.player = getNumPieces(board) % 2 == 0 ? "black" : "white";
+                board.player = (me ?? json.Turn % 2) == 0 ? "black" : "white";
                 board.repaint();
                 displayScores(board);
                 gameOver = json.GameOver;
@@ -235,6 +357,59 @@ window.addEventListener('load', () => {
                 }
                 // Disables mousemove and click events
                 board = null;
+                localStorage.removeItem('rejoin');
+                break;
+            }
+            case 'Timeout': {
+                const p = json.Player == 0 ? 'Black' : 'White';
+                const w = json.Player == 0 ? 'white' : 'black';
+                drawText(canvas.getContext('2d'), 
+                    `${p} ran out of time - ${w} wins!`, 
+                    new Point(canvas.width/2, 40),
+                    'red', 
+                    'bold 28px sans', 
+                    true);
+                board = null;
+                localStorage.removeItem('rejoin');
+                break;
+            }
+            case 'Register':
+            case 'Login':
+                if (json.Secret) {
+                    saveAccount(json.Name, json.Secret);
+                }
+                $('#account').innerText = `Playing as ${json.Name}`;
+                break;
+            case 'Leaderboard': {
+                const list = $('#leaderboard');
+                list.innerHTML = '';
+                json.Leaderboard.forEach(s => {
+                    const li = document.createElement('li');
+                    li.innerText = `${s.Name} ${Math.round(s.Rating)} (${s.Games})`;
+                    li.addEventListener('click', () => {
+                        conn.send(JSON.stringify({Action: 'Profile', Name: s.Name}));
+                    });
+                    list.appendChild(li);
+                });
+                break;
+            }
+            case 'Profile': {
+                const p = json.Profile;
+                chatNotice(`${p.Name}: rating ${Math.round(p.Rating)}, ${p.Wins} wins, ${p.Losses} losses, ${p.Draws} draws`);
+                break;
+            }
+            case 'Takeback': {
+                const ok = confirm('Your opponent asks to take back their last move. Accept?');
+                conn.send(JSON.stringify({Action: ok ? 'AcceptTakeback' : 'DeclineTakeback', Key: key}));
+                break;
+            }
+            case 'DeclineTakeback':
+                chatNotice('Takeback declined');
+                break;
+            case 'Disconnect':
+            case 'Reconnect': {
+                const p = json.Player == 0 ? 'Black' : 'White';
+                chatNotice(json.Action == 'Disconnect' ? `${p} lost connection` : `${p} is back`);
                 break;
             }
             case 'Chat': {
@@ -252,15 +427,34 @@ window.addEventListener('load', () => {
             drawText(ctx, `Black: ${bscore}`, new Point(canvas.width/2, 300), 'red', 'bold 48px sans', true);
             drawText(ctx, `White: ${wscore}`, new Point(canvas.width/2, 350), 'red', 'bold 48px sans', true);
             board = null;
+            localStorage.removeItem('rejoin');
         }
     }
     
-    conn.onopen = e => {
-        conn.send(JSON.stringify({Action: 'ListBoards'}));
+    // Reconnects after a drop and takes back the seat of the last game
+    function connect() {
+        conn = new WebSocket(`ws://${location.host}/ws`);
+        conn.onmessage = onMessage;
+        conn.onopen = e => {
+            conn.send(JSON.stringify({Action: 'ListBoards'}));
+            // Log in first so rejoined games keep their accounts
+            const account = JSON.parse(localStorage.getItem('account'));
+            if (account) {
+                conn.send(JSON.stringify({Action: 'Login', Name: account.Name, Secret: account.Secret}));
+            }
+            const saved = JSON.parse(localStorage.getItem('rejoin'));
+            if (saved) {
+                conn.send(JSON.stringify({Action: 'Rejoin', Key: saved.Key, Token: saved.Token}));
+            }
+        }
+        conn.onclose = e => {
+            setTimeout(connect, 1000);
+        }
     }
+    connect();
     
     setInterval(e => {
-        if (!conn.readyState == 1) return;
+        if (conn.readyState != 1) return;
         conn.send(JSON.stringify({Action: 'ListGames'}));
     }, 1000);
 
@@ -315,7 +509,7 @@ window.addEventListener('load', () => {
             if (move != -1) {
                 // Take back move and wait for server to give us the updated board
                 board.points[move].player = null;
-                board.player = getTurn(board) % 2 == 0 ? "black" : "white";
+                board.player = me == 0 ? "black" : "white";
                 conn.send(JSON.stringify({Action: 'Move', Key: key, Move: move}));    
             }
         }
@@ -324,7 +518,8 @@ window.addEventListener('load', () => {
     $('#new').addEventListener('click', () => {
         const pts = transformPoints(board);
         const ns = transformNeighbors(board);
-        const req = {Action: 'NewGame', AIGame: false, BoardName: boardName, Points: pts, Neighbors: ns};
+        const req = {Action: 'NewGame', AIGame: false, BoardName: boardName, Points: pts, Neighbors: ns, TimeControl: timeControl()};
+        aiGame = false;
         conn.send(JSON.stringify(req));
         $('#new').disabled = true;
         $('#new-ai').disabled = true;
@@ -333,7 +528,11 @@ window.addEventListener('load', () => {
     $('#new-ai').addEventListener('click', () => {
         const pts = transformPoints(board);
         const ns = transformNeighbors(board);
-        const req = {Action: 'NewGame', AIGame: true, BoardName: boardName, Points: pts, Neighbors: ns};
+        const engine = $('#engine').value;
+        const level = $('#level').value;
+        const aiColor = $('#ai-color').value;
+        const req = {Action: 'NewGame', AIGame: true, BoardName: boardName, Points: pts, Neighbors: ns, Engine: engine, Level: level, AIColor: aiColor, TimeControl: timeControl()};
+        aiGame = true;
         conn.send(JSON.stringify(req));
         $('#new').disabled = true;
         $('#new-ai').disabled = true;
@@ -347,11 +546,28 @@ window.addEventListener('load', () => {
         conn.send(JSON.stringify(req));
     });
 
+    $('#watch').addEventListener('click', () => {
+        const idx = $('select[name="live-list"]').selectedIndex;
+        if (idx == -1) return;
+        const k = parseInt($('select[name="live-list"]').options[idx].value);
+        conn.send(JSON.stringify({Action: 'Watch', Key: k}));
+    });
+
     $('#concede').addEventListener('click', () => {
         if (!board || (!key && key !== 0)) return;
         conn.send(JSON.stringify({Action: 'Concede', Key: key}));
     });
 
+    $('#takeback').addEventListener('click', () => {
+        if (!board || me === null || (!key && key !== 0)) return;
+        conn.send(JSON.stringify({Action: aiGame ? 'Undo' : 'Takeback', Key: key}));
+    });
+
+    $('#record').addEventListener('click', () => {
+        if (!key && key !== 0) return;
+        window.open(`/record?key=${key}`);
+    });
+
     function sendMessage() {
         if (!key && key !== 0) return;
         conn.send(JSON.stringify({Key: key, Action: 'Chat', Text: $('#message').value}));
@@ -365,4 +581,17 @@ window.addEventListener('load', () => {
     });
 
     $('#send').addEventListener('click', sendMessage);
+
+    $('#register').addEventListener('click', () => {
+        const name = $('#name').value.trim();
+        if (!name) return;
+        conn.send(JSON.stringify({Action: 'Register', Name: name}));
+    });
+
+    $('#leaderboard-button').addEventListener('click', () => {
+        const board = $('#board-ratings').checked && boardName ? boardName : '';
+        conn.send(JSON.stringify({Action: 'Leaderboard', BoardName: board}));
+    });
+
+    setInterval(displayClock, 200);
 });